@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jasonrogena/fusee/internal/app/fusee/control"
+	log "github.com/sirupsen/logrus"
+)
+
+const ctlUsage = `Usage:
+  fusee ctl <path to the control socket> list
+  fusee ctl <path to the control socket> stats <mount name>
+  fusee ctl <path to the control socket> tree <mount name>
+  fusee ctl <path to the control socket> invalidate <mount name> [path relative to the mount's root]`
+
+// ctl sends a request to the control socket of a running fusee and prints the result. Returns
+// the status fusee should exit with.
+func ctl(args []string) int {
+	if len(args) < 2 {
+		log.Error(ctlUsage)
+		return exitCodeUsageError
+	}
+	request := control.Request{Command: args[1]}
+	switch request.Command {
+	case control.CommandList:
+		if len(args) != 2 {
+			log.Error(ctlUsage)
+			return exitCodeUsageError
+		}
+	case control.CommandStats, control.CommandTree:
+		if len(args) != 3 {
+			log.Error(ctlUsage)
+			return exitCodeUsageError
+		}
+		request.Mount = args[2]
+	case control.CommandInvalidate:
+		if len(args) != 3 && len(args) != 4 {
+			log.Error(ctlUsage)
+			return exitCodeUsageError
+		}
+		request.Mount = args[2]
+		if len(args) == 4 {
+			request.Path = args[3]
+		}
+	default:
+		log.Error(ctlUsage)
+		return exitCodeUsageError
+	}
+
+	result, sendErr := control.Send(args[0], request)
+	if sendErr != nil {
+		log.Error(sendErr.Error())
+		return exitCodeControlError
+	}
+	if len(result) > 0 {
+		var indentedResult bytes.Buffer
+		if indentErr := json.Indent(&indentedResult, result, "", "  "); indentErr != nil {
+			log.Error(indentErr.Error())
+			return exitCodeControlError
+		}
+		fmt.Println(indentedResult.String())
+	}
+
+	return exitCodeSuccess
+}
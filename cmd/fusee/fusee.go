@@ -1,32 +1,205 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/jasonrogena/fusee/internal/app/fusee/config"
-	"github.com/jasonrogena/fusee/internal/app/fusee/mount"
+	"github.com/jasonrogena/fusee/internal/app/fusee/control"
+	"github.com/jasonrogena/fusee/internal/app/fusee/supervisor"
+	"github.com/jasonrogena/fusee/internal/pkg/audit"
+	"github.com/jasonrogena/fusee/internal/pkg/metrics"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	exitCodeSuccess       = 0
+	exitCodeMountError    = 1
+	exitCodeShutdownError = 2
+	exitCodeInvalidConfig = 3
+	exitCodeControlError  = 4
+	exitCodeMetricsError  = 5
+	exitCodeAuditError    = 6
+	exitCodeUsageError    = 64
+)
+
+const configWatchInterval = 2 * time.Second
+
+const usage = `Usage:
+  fusee [-debug] [-watch] <path to the configuration>
+  fusee validate <path to the configuration>
+  fusee render <path to the configuration> [mount name] [-depth N]
+  fusee ctl <path to the control socket> <command> [arguments]`
+
 func main() {
 	debug := flag.Bool("debug", false, "Print debug data")
+	watchConfig := flag.Bool("watch", false, "Reload the configuration whenever the configuration file changes")
 	flag.Parse()
 	if len(flag.Args()) < 1 {
-		log.Fatal("Usage:\n  fusee <path to the configuration>")
-	}
-	config, configErr := config.NewConfig(flag.Arg(0))
-	if configErr != nil {
-		log.Fatal(configErr.Error())
-		return
+		log.Fatal(usage)
 	}
 	if *debug {
 		log.SetLevel(log.DebugLevel)
 	}
-	for curMountName, curMountConf := range config.Mounts {
-		curMount := mount.NewRoot(curMountName, curMountConf)
-		mountErr := curMount.Mount(*debug)
-		if mountErr != nil {
-			log.Error(mountErr.Error())
+
+	switch flag.Arg(0) {
+	case "validate":
+		os.Exit(validate(flag.Args()[1:]))
+	case "render":
+		os.Exit(render(flag.Args()[1:]))
+	case "ctl":
+		os.Exit(ctl(flag.Args()[1:]))
+	default:
+		os.Exit(serve(flag.Arg(0), *watchConfig, *debug))
+	}
+}
+
+// serve mounts all the mounts in the configuration and blocks until they are all unmounted.
+// Returns the status fusee should exit with.
+func serve(configPath string, watchConfig bool, debug bool) int {
+	config, configErr := config.NewConfig(configPath)
+	if configErr != nil {
+		log.Error(configErr.Error())
+		return exitCodeInvalidConfig
+	}
+	// os.Exit does not run deferred functions so the cleanup is also run explicitly before exiting
+	cleanups := []func(){}
+	cleanupOnce := new(sync.Once)
+	cleanup := func() {
+		cleanupOnce.Do(func() {
+			for i := len(cleanups) - 1; i >= 0; i-- {
+				cleanups[i]()
+			}
+		})
+	}
+	defer cleanup()
+	exit := func(code int) {
+		cleanup()
+		os.Exit(code)
+	}
+	if len(config.AuditLog) > 0 {
+		auditLog, auditErr := audit.Open(config.AuditLog, config.AuditRedact)
+		if auditErr != nil {
+			log.Error(fmt.Sprintf("Unable to open the audit log: %v", auditErr))
+			return exitCodeAuditError
+		}
+		audit.SetDefault(auditLog)
+		cleanups = append(cleanups, func() { auditLog.Close() })
+	}
+	mountSupervisor := supervisor.NewSupervisor(config, debug)
+	if len(config.ControlSocket) > 0 {
+		controlServer := control.NewServer(config.ControlSocket, mountSupervisor)
+		if controlErr := controlServer.Start(); controlErr != nil {
+			log.Error(fmt.Sprintf("Unable to start the control socket: %v", controlErr))
+			return exitCodeControlError
+		}
+		cleanups = append(cleanups, controlServer.Stop)
+	}
+	if len(config.MetricsAddress) > 0 {
+		metricsListener, metricsErr := startMetricsServer(config.MetricsAddress)
+		if metricsErr != nil {
+			log.Error(fmt.Sprintf("Unable to start the metrics server: %v", metricsErr))
+			return exitCodeMetricsError
+		}
+		cleanups = append(cleanups, func() { metricsListener.Close() })
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Info(fmt.Sprintf("Received %v, shutting down", sig))
+		go func() {
+			shutdownErr := mountSupervisor.Shutdown()
+			if shutdownErr != nil {
+				// Mounts that could not be unmounted will never end so don't wait for them
+				log.Error(shutdownErr.Error())
+				exit(exitCodeShutdownError)
+			}
+		}()
+		// A second signal means the user is no longer willing to wait for the shutdown
+		sig = <-signals
+		log.Error(fmt.Sprintf("Received %v while shutting down, exiting without waiting for the mounts", sig))
+		exit(exitCodeShutdownError)
+	}()
+	go handleReloads(configPath, watchConfig, mountSupervisor)
+
+	mountSupervisor.Start()
+	mountErrs, unmountErrs := mountSupervisor.Wait()
+	if len(unmountErrs) > 0 {
+		log.Error(fmt.Sprintf("%d of %d mounts could not be unmounted", len(unmountErrs), len(config.Mounts)))
+		return exitCodeShutdownError
+	}
+	if len(mountErrs) > 0 {
+		log.Error(fmt.Sprintf("%d of %d mounts could not be mounted", len(mountErrs), len(config.Mounts)))
+		return exitCodeMountError
+	}
+
+	return exitCodeSuccess
+}
+
+// startMetricsServer serves the metrics on /metrics over HTTP in the background.
+func startMetricsServer(address string) (net.Listener, error) {
+	listener, listenErr := net.Listen("tcp", address)
+	if listenErr != nil {
+		return nil, listenErr
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.DefaultRegistry.Handler())
+	go func() {
+		serveErr := http.Serve(listener, mux)
+		if serveErr != nil && !errors.Is(serveErr, net.ErrClosed) {
+			log.Error(fmt.Sprintf("Metrics server stopped: %v", serveErr))
+		}
+	}()
+	log.Info(fmt.Sprintf("Serving metrics on http://%s/metrics", listener.Addr()))
+
+	return listener, nil
+}
+
+// handleReloads reloads the configuration every time fusee receives SIGHUP and, if watch is true,
+// every time the configuration file is modified.
+func handleReloads(configPath string, watch bool, mountSupervisor *supervisor.Supervisor) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	var watchTicks <-chan time.Time
+	var lastModTime time.Time
+	if watch {
+		watchTicks = time.NewTicker(configWatchInterval).C
+		if info, statErr := os.Stat(configPath); statErr == nil {
+			lastModTime = info.ModTime()
+		}
+	}
+
+	for {
+		select {
+		case <-hangups:
+			log.Info("Received SIGHUP, reloading the configuration")
+		case <-watchTicks:
+			info, statErr := os.Stat(configPath)
+			if statErr != nil || info.ModTime().Equal(lastModTime) {
+				continue
+			}
+			lastModTime = info.ModTime()
+			log.Info("Configuration file has changed, reloading the configuration")
+		}
+
+		newConfig, configErr := config.NewConfig(configPath)
+		if configErr != nil {
+			log.Error(fmt.Sprintf("Not reloading the configuration because it could not be parsed: %v", configErr))
+			continue
+		}
+		reloadErr := mountSupervisor.Reload(newConfig)
+		if reloadErr != nil {
+			log.Error(fmt.Sprintf("Configuration partially reloaded: %v", reloadErr))
 		}
 	}
 }
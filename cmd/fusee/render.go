@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/jasonrogena/fusee/internal/app/fusee/config"
+	"github.com/jasonrogena/fusee/internal/app/fusee/mount"
+	log "github.com/sirupsen/logrus"
+)
+
+// render prints the trees of the mounts in the configuration without mounting them. Returns the
+// status fusee should exit with.
+func render(args []string) int {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	depth := flags.Int("depth", -1, "Do not expand directories deeper than this. Expand all directories if negative")
+	positionalArgs, parseErr := parseInterspersed(flags, args)
+	if parseErr != nil {
+		return exitCodeUsageError
+	}
+	if len(positionalArgs) < 1 || len(positionalArgs) > 2 {
+		log.Error("Usage:\n  fusee render <path to the configuration> [mount name] [-depth N]")
+		return exitCodeUsageError
+	}
+
+	conf, configErr := config.NewConfig(positionalArgs[0])
+	if configErr != nil {
+		log.Error(configErr.Error())
+		return exitCodeInvalidConfig
+	}
+	mountNames := []string{}
+	if len(positionalArgs) == 2 {
+		if _, found := conf.Mounts[positionalArgs[1]]; !found {
+			log.Error(fmt.Sprintf("Mount '%s' is not defined in the configuration", positionalArgs[1]))
+			return exitCodeUsageError
+		}
+		mountNames = append(mountNames, positionalArgs[1])
+	} else {
+		for curName := range conf.Mounts {
+			mountNames = append(mountNames, curName)
+		}
+		sort.Strings(mountNames)
+	}
+
+	for curIndex, curName := range mountNames {
+		if curIndex > 0 {
+			fmt.Println()
+		}
+		root, rootErr := mount.NewRoot(curName, conf.Mounts[curName])
+		if rootErr != nil {
+			log.Error(rootErr.Error())
+			return exitCodeInvalidConfig
+		}
+		fmt.Printf("[%s] %s\n", curName, conf.Mounts[curName].Path)
+		root.Render(os.Stdout, *depth)
+	}
+
+	return exitCodeSuccess
+}
+
+// parseInterspersed parses the flags in args, allowing them to come after positional arguments.
+// Returns the positional arguments.
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	positionalArgs := []string{}
+	for {
+		if parseErr := flags.Parse(args); parseErr != nil {
+			return nil, parseErr
+		}
+		if flags.NArg() == 0 {
+			return positionalArgs, nil
+		}
+		positionalArgs = append(positionalArgs, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
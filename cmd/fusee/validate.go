@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jasonrogena/fusee/internal/app/fusee/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// validate prints the problems found in the configuration file and returns the status fusee
+// should exit with.
+func validate(args []string) int {
+	if len(args) < 1 {
+		log.Error("Usage:\n  fusee validate <path to the configuration>")
+		return exitCodeUsageError
+	}
+	configPath := args[0]
+	diagnostics, validateErr := config.Validate(configPath)
+	if validateErr != nil {
+		log.Error(validateErr.Error())
+		return exitCodeInvalidConfig
+	}
+	for _, curDiagnostic := range diagnostics {
+		fmt.Printf("%s:%s\n", configPath, curDiagnostic.String())
+	}
+	if len(diagnostics) > 0 {
+		fmt.Printf("Found %d problem(s) in %s\n", len(diagnostics), configPath)
+		return exitCodeInvalidConfig
+	}
+
+	fmt.Printf("%s is valid\n", configPath)
+	return exitCodeSuccess
+}
@@ -3,34 +3,113 @@ package config
 import "github.com/BurntSushi/toml"
 
 type Config struct {
-	Mounts map[string]Mount
+	ShutdownGraceSeconds uint64
+	ControlSocket        string
+	MetricsAddress       string
+	AuditLog             string
+	AuditRedact          []string
+	Mounts               map[string]Mount
 }
 
 type Mount struct {
-	Path          string
-	ReadCommand   string
-	NameSeparator string
-	Mode          uint32
-	ThreadCount   uint
-	Cache         bool
-	CacheSeconds  uint64
-	Directory     Directory
-	File          File
+	Path           string
+	ReadCommand    string
+	ReadArgs       []string
+	Coprocess      []string
+	NameSeparator  string
+	ListFormat     string
+	Mode           uint32
+	ThreadCount    uint
+	Cache          bool
+	CacheSeconds   uint64
+	PerCaller      bool
+	TimeoutSeconds float64
+	StderrLogLevel string
+	Env            map[string]string
+	WorkingDir     string
+	CleanEnv       bool
+	Access         Access
+	Directory      Directory
+	File           File
+	// Mount options. Changing any of them requires the mount to be remounted
+	AllowOther             bool
+	DefaultPermissions     bool
+	FsName                 string
+	Subtype                string
+	EntryTimeoutSeconds    float64
+	AttrTimeoutSeconds     float64
+	NegativeTimeoutSeconds float64
+	MaxRead                int
+	MaxReadAhead           int
+	DirectMount            bool
+}
+
+// HasSameMountOptions returns true if the options the kernel needs to be given when mounting are
+// the same in both mounts.
+func (m Mount) HasSameMountOptions(other Mount) bool {
+	return m.Path == other.Path &&
+		m.AllowOther == other.AllowOther &&
+		m.DefaultPermissions == other.DefaultPermissions &&
+		m.FsName == other.FsName &&
+		m.Subtype == other.Subtype &&
+		m.EntryTimeoutSeconds == other.EntryTimeoutSeconds &&
+		m.AttrTimeoutSeconds == other.AttrTimeoutSeconds &&
+		m.NegativeTimeoutSeconds == other.NegativeTimeoutSeconds &&
+		m.MaxRead == other.MaxRead &&
+		m.MaxReadAhead == other.MaxReadAhead &&
+		m.DirectMount == other.DirectMount
+}
+
+// Access is a policy of which callers can access a mount or file. A caller is allowed if it
+// matches every criterion that is set. Callers are allowed if no criterion is set.
+type Access struct {
+	// The user IDs allowed
+	UIDs []uint32
+	// The group IDs allowed
+	GIDs []uint32
+	// Glob patterns matched against the path of the caller's executable
+	Exes []string
+	// Glob patterns matched against the caller's command line, with arguments separated by spaces
+	Cmdlines []string
+}
+
+// IsSet returns true if any of the policy's criteria is set.
+func (a Access) IsSet() bool {
+	return len(a.UIDs) > 0 || len(a.GIDs) > 0 || len(a.Exes) > 0 || len(a.Cmdlines) > 0
 }
 
 type Directory struct {
-	ReadCommand   string
-	NameSeparator string
-	Mode          uint32
-	Cache         bool
-	CacheSeconds  uint64
+	ReadCommand    string
+	ReadArgs       []string
+	TypeCommand    string
+	LookupCommand  string
+	NameSeparator  string
+	ListFormat     string
+	Mode           uint32
+	Cache          bool
+	CacheSeconds   uint64
+	PerCaller      bool
+	TimeoutSeconds float64
+	ExitCodeErrno  map[string]string
+	Env            map[string]string
+	WorkingDir     string
 }
 
 type File struct {
-	ReadCommand  string
-	Mode         uint32
-	Cache        bool
-	CacheSeconds uint64
+	ReadCommand    string
+	ReadArgs       []string
+	Mode           uint32
+	Cache          bool
+	CacheSeconds   uint64
+	PerCaller      bool
+	TimeoutSeconds float64
+	ExitCodeErrno  map[string]string
+	Env            map[string]string
+	WorkingDir     string
+	Access         Access
+	// Let the kernel cache the contents of files between refreshes, instead of reading them
+	// directly from fusee
+	KeepCache bool
 }
 
 func NewConfig(path string) (Config, error) {
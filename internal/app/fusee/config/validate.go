@@ -0,0 +1,452 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jasonrogena/fusee/internal/pkg/command"
+	fuseefs "github.com/jasonrogena/fusee/internal/pkg/fs"
+	log "github.com/sirupsen/logrus"
+)
+
+// Diagnostic describes a problem found in a configuration file.
+type Diagnostic struct {
+	// The line in the configuration file the problem is on. Is 0 if the line is not known.
+	Line    int
+	Key     string
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	location := ""
+	if d.Line > 0 {
+		location = fmt.Sprintf("%d: ", d.Line)
+	}
+	if len(d.Key) > 0 {
+		return fmt.Sprintf("%s%s: %s", location, d.Key, d.Message)
+	}
+
+	return location + d.Message
+}
+
+// Validate checks the configuration file in path for problems that NewConfig does not report,
+// such as unknown keys, missing required fields and templates that do not parse. An error is
+// returned only if the file cannot be read.
+func Validate(path string) ([]Diagnostic, error) {
+	content, readErr := os.ReadFile(path)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	config := Config{}
+	metaData, decodeErr := toml.Decode(string(content), &config)
+	if decodeErr != nil {
+		var parseErr toml.ParseError
+		if errors.As(decodeErr, &parseErr) {
+			return []Diagnostic{{Line: parseErr.Position.Line, Message: parseErr.Message}}, nil
+		}
+		return []Diagnostic{{Message: decodeErr.Error()}}, nil
+	}
+
+	lines := getKeyLines(string(content))
+	diagnostics := []Diagnostic{}
+	for _, curKey := range metaData.Undecoded() {
+		keyName := strings.Join(curKey, ".")
+		diagnostics = append(diagnostics, Diagnostic{
+			Line:    lookupLine(lines, keyName),
+			Key:     keyName,
+			Message: "unknown key",
+		})
+	}
+
+	for curIndex, curPattern := range config.AuditRedact {
+		if _, compileErr := regexp.Compile(curPattern); compileErr != nil {
+			key := fmt.Sprintf("auditRedact[%d]", curIndex)
+			diagnostics = append(diagnostics, Diagnostic{
+				Line:    lookupLine(lines, key),
+				Key:     key,
+				Message: fmt.Sprintf("regular expression does not compile: %v", compileErr),
+			})
+		}
+	}
+
+	mountNames := []string{}
+	for curName := range config.Mounts {
+		mountNames = append(mountNames, curName)
+	}
+	sort.Strings(mountNames)
+	mountPaths := map[string]string{}
+	for _, curName := range mountNames {
+		curMount := config.Mounts[curName]
+		prefix := "mounts." + curName
+		for _, curProblem := range validateMount(curMount) {
+			key := prefix
+			if len(curProblem.key) > 0 {
+				key = key + "." + curProblem.key
+			}
+			diagnostics = append(diagnostics, Diagnostic{
+				Line:    lookupLine(lines, key),
+				Key:     key,
+				Message: curProblem.message,
+			})
+		}
+		if otherName, pathUsed := mountPaths[curMount.Path]; pathUsed && len(curMount.Path) > 0 {
+			diagnostics = append(diagnostics, Diagnostic{
+				Line:    lookupLine(lines, prefix+".path"),
+				Key:     prefix + ".path",
+				Message: fmt.Sprintf("path is also used by mount '%s'", otherName),
+			})
+		}
+		mountPaths[curMount.Path] = curName
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Line < diagnostics[j].Line
+	})
+	return diagnostics, nil
+}
+
+type problem struct {
+	// The key, relative to the mount's table, the problem is about
+	key     string
+	message string
+}
+
+func validateMount(mount Mount) []problem {
+	problems := []problem{}
+	if len(mount.Path) == 0 {
+		problems = append(problems, problem{"path", "required field is missing"})
+	} else if pathErr := validateMountPath(mount.Path); pathErr != nil {
+		problems = append(problems, problem{"path", pathErr.Error()})
+	}
+
+	hasReadCommand := len(mount.ReadCommand) > 0 || len(mount.ReadArgs) > 0
+	hasDirectoryReadCommand := len(mount.Directory.ReadCommand) > 0 || len(mount.Directory.ReadArgs) > 0
+	hasFileReadCommand := len(mount.File.ReadCommand) > 0 || len(mount.File.ReadArgs) > 0
+	if len(mount.Coprocess) > 0 {
+		// The coprocess replaces the read commands and lists entries separated by NUL characters
+		if hasReadCommand {
+			problems = append(problems, problem{"readCommand", "cannot be set together with coprocess"})
+		}
+		if hasDirectoryReadCommand {
+			problems = append(problems, problem{"directory.readCommand", "cannot be set together with coprocess"})
+		}
+		if hasFileReadCommand {
+			problems = append(problems, problem{"file.readCommand", "cannot be set together with coprocess"})
+		}
+		if len(mount.Directory.TypeCommand) > 0 {
+			problems = append(problems, problem{"directory.typeCommand", "cannot be set together with coprocess"})
+		}
+		if len(mount.Directory.LookupCommand) > 0 {
+			problems = append(problems, problem{"directory.lookupCommand", "cannot be set together with coprocess"})
+		}
+		if len(mount.Coprocess[0]) == 0 {
+			problems = append(problems, problem{"coprocess", "the helper's command cannot be blank"})
+		}
+	} else {
+		if !hasReadCommand && !hasDirectoryReadCommand {
+			problems = append(problems, problem{"readCommand", "required field is missing, and directory.readCommand is not set"})
+		}
+		rootListFormat := mount.ListFormat
+		if len(rootListFormat) == 0 {
+			rootListFormat = mount.Directory.ListFormat
+		}
+		if isTextListFormat(rootListFormat) && len(mount.NameSeparator) == 0 && len(mount.Directory.NameSeparator) == 0 {
+			problems = append(problems, problem{"nameSeparator", "required field is missing, and directory.nameSeparator is not set"})
+		}
+		if !hasFileReadCommand {
+			problems = append(problems, problem{"file.readCommand", "required field is missing"})
+		}
+	}
+	problems = append(problems, validateReadCommand("", mount.ReadCommand, mount.ReadArgs)...)
+	problems = append(problems, validateMode("mode", mount.Mode, true)...)
+	if len(mount.StderrLogLevel) > 0 {
+		if _, levelErr := log.ParseLevel(mount.StderrLogLevel); levelErr != nil {
+			problems = append(problems, problem{"stderrLogLevel", levelErr.Error()})
+		}
+	}
+
+	problems = append(problems, validateMountOptions(mount)...)
+	problems = append(problems, validateEnvironment("", mount.Env, mount.WorkingDir)...)
+	problems = append(problems, validateEnvironment("directory.", mount.Directory.Env, mount.Directory.WorkingDir)...)
+	problems = append(problems, validateEnvironment("file.", mount.File.Env, mount.File.WorkingDir)...)
+
+	problems = append(problems, validateReadCommand("file.", mount.File.ReadCommand, mount.File.ReadArgs)...)
+	problems = append(problems, validateMode("file.mode", mount.File.Mode, false)...)
+	problems = append(problems, validateExitCodeErrno("file.exitCodeErrno", mount.File.ExitCodeErrno)...)
+	if mount.File.KeepCache && !mount.File.Cache {
+		problems = append(problems, problem{"file.keepCache", "requires file.cache to be true"})
+	}
+
+	problems = append(problems, validateListFormat("listFormat", mount.ListFormat)...)
+	problems = append(problems, validateListFormat("directory.listFormat", mount.Directory.ListFormat)...)
+	if hasDirectoryReadCommand {
+		if isTextListFormat(mount.Directory.ListFormat) && len(mount.Directory.NameSeparator) == 0 {
+			problems = append(problems, problem{"directory.nameSeparator", "required field is missing"})
+		}
+		problems = append(problems, validateReadCommand("directory.", mount.Directory.ReadCommand, mount.Directory.ReadArgs)...)
+	}
+	if hasDirectoryReadCommand || len(mount.Coprocess) > 0 {
+		problems = append(problems, validateMode("directory.mode", mount.Directory.Mode, true)...)
+	}
+	problems = append(problems, validateTemplate("directory.typeCommand", mount.Directory.TypeCommand)...)
+	problems = append(problems, validateTemplate("directory.lookupCommand", mount.Directory.LookupCommand)...)
+	problems = append(problems, validateExitCodeErrno("directory.exitCodeErrno", mount.Directory.ExitCodeErrno)...)
+
+	return problems
+}
+
+// The largest read the FUSE server can reply to
+const maxReadSize = 128 * 1024
+
+// ValidateMountOptions returns an error describing the problems with the options the mount would be
+// mounted with, if there are any.
+func ValidateMountOptions(mount Mount) error {
+	messages := []string{}
+	for _, curProblem := range validateMountOptions(mount) {
+		messages = append(messages, fmt.Sprintf("%s %s", curProblem.key, curProblem.message))
+	}
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "; "))
+	}
+
+	return nil
+}
+
+func validateMountOptions(mount Mount) []problem {
+	problems := []problem{}
+	if mount.AllowOther && os.Geteuid() != 0 && !isUserAllowOtherEnabled() {
+		problems = append(problems, problem{"allowOther", "only root can use allowOther unless user_allow_other is set in /etc/fuse.conf"})
+	}
+	if strings.ContainsAny(mount.FsName, ", ") {
+		problems = append(problems, problem{"fsName", "cannot contain commas or spaces"})
+	}
+	if strings.ContainsAny(mount.Subtype, ", ") {
+		problems = append(problems, problem{"subtype", "cannot contain commas or spaces"})
+	}
+	timeouts := map[string]float64{
+		"entryTimeoutSeconds":    mount.EntryTimeoutSeconds,
+		"attrTimeoutSeconds":     mount.AttrTimeoutSeconds,
+		"negativeTimeoutSeconds": mount.NegativeTimeoutSeconds,
+	}
+	for _, curKey := range []string{"entryTimeoutSeconds", "attrTimeoutSeconds", "negativeTimeoutSeconds"} {
+		if timeouts[curKey] < 0 {
+			problems = append(problems, problem{curKey, "cannot be negative"})
+		}
+	}
+	if mount.MaxRead < 0 || mount.MaxRead > maxReadSize {
+		problems = append(problems, problem{"maxRead", fmt.Sprintf("should be between 0 and %d", maxReadSize)})
+	}
+	if mount.MaxReadAhead < 0 {
+		problems = append(problems, problem{"maxReadAhead", "cannot be negative"})
+	}
+
+	return problems
+}
+
+func isUserAllowOtherEnabled() bool {
+	content, readErr := os.ReadFile("/etc/fuse.conf")
+	if readErr != nil {
+		return false
+	}
+	for _, curLine := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(curLine) == "user_allow_other" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validateMountPath(path string) error {
+	info, statErr := os.Stat(path)
+	if statErr != nil {
+		return statErr
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("'%s' is not a directory", path)
+	}
+	entries, readErr := os.ReadDir(path)
+	if readErr != nil {
+		return readErr
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("'%s' is not empty", path)
+	}
+
+	return nil
+}
+
+// The formats directory read commands can list entries in
+var listFormats = []string{"text", "json", "jsonl"}
+
+func isTextListFormat(listFormat string) bool {
+	return len(listFormat) == 0 || listFormat == "text"
+}
+
+func validateListFormat(key string, listFormat string) []problem {
+	if len(listFormat) == 0 {
+		return []problem{}
+	}
+	for _, curFormat := range listFormats {
+		if listFormat == curFormat {
+			return []problem{}
+		}
+	}
+
+	return []problem{{key, fmt.Sprintf("should be one of %s", strings.Join(listFormats, ", "))}}
+}
+
+// validateReadCommand validates the readCommand and readArgs keys of the table keyPrefix is for.
+func validateReadCommand(keyPrefix string, readCommand string, readArgs []string) []problem {
+	if len(readCommand) > 0 && len(readArgs) > 0 {
+		return []problem{{keyPrefix + "readArgs", "cannot be set together with readCommand"}}
+	}
+	problems := validateTemplate(keyPrefix+"readCommand", readCommand)
+	for curIndex, curArg := range readArgs {
+		problems = append(problems, validateTemplate(fmt.Sprintf("%sreadArgs[%d]", keyPrefix, curIndex), curArg)...)
+	}
+
+	return problems
+}
+
+func validateTemplate(key string, text string) []problem {
+	if _, parseErr := command.ParseTemplate(text); parseErr != nil {
+		return []problem{{key, fmt.Sprintf("template does not parse: %v", parseErr)}}
+	}
+
+	return []problem{}
+}
+
+func validateMode(key string, mode uint32, isDirectory bool) []problem {
+	if mode == 0 {
+		return []problem{{key, "mode is not set, no one will be able to access the node"}}
+	}
+	if mode&^uint32(os.ModePerm|0o7000) != 0 {
+		return []problem{{key, fmt.Sprintf("mode %#o contains bits other than the permission bits", mode)}}
+	}
+	if isDirectory && mode&0o111 == 0 {
+		return []problem{{key, fmt.Sprintf("mode %#o does not allow the directory to be traversed", mode)}}
+	}
+
+	return []problem{}
+}
+
+// validateEnvironment validates the env and workingDir keys of the table keyPrefix is for.
+func validateEnvironment(keyPrefix string, env map[string]string, workingDir string) []problem {
+	problems := []problem{}
+	names := []string{}
+	for curName := range env {
+		names = append(names, curName)
+	}
+	sort.Strings(names)
+	for _, curName := range names {
+		if len(curName) == 0 || strings.ContainsAny(curName, "=\x00") {
+			problems = append(problems, problem{keyPrefix + "env", fmt.Sprintf("'%s' is not a valid environment variable name", curName)})
+		}
+	}
+	if len(workingDir) > 0 {
+		if info, statErr := os.Stat(workingDir); statErr != nil {
+			problems = append(problems, problem{keyPrefix + "workingDir", statErr.Error()})
+		} else if !info.IsDir() {
+			problems = append(problems, problem{keyPrefix + "workingDir", fmt.Sprintf("'%s' is not a directory", workingDir)})
+		}
+	}
+
+	return problems
+}
+
+func validateExitCodeErrno(key string, exitCodeErrno map[string]string) []problem {
+	problems := []problem{}
+	exitCodes := []string{}
+	for curExitCode := range exitCodeErrno {
+		exitCodes = append(exitCodes, curExitCode)
+	}
+	sort.Strings(exitCodes)
+	for _, curExitCode := range exitCodes {
+		if curExitCode != "default" {
+			if code, parseErr := strconv.Atoi(curExitCode); parseErr != nil || code < 1 || code > 255 {
+				problems = append(problems, problem{key, fmt.Sprintf("'%s' is not an exit code between 1 and 255, or 'default'", curExitCode)})
+			}
+		}
+		if _, parseErr := fuseefs.ParseErrno(exitCodeErrno[curExitCode]); parseErr != nil {
+			problems = append(problems, problem{key, parseErr.Error()})
+		}
+	}
+
+	return problems
+}
+
+var tableHeaderRegex = regexp.MustCompile(`^\[\[?\s*([^\]]+?)\s*\]\]?`)
+var arrayIndexRegex = regexp.MustCompile(`\[\d+\]`)
+var keyValueRegex = regexp.MustCompile(`^([A-Za-z0-9_\-."' ]+?)\s*=`)
+
+// lookupLine returns the line the key is defined on. If the key is not defined, the line of the
+// closest table containing the key is returned.
+func lookupLine(lines map[string]int, key string) int {
+	// Array elements are on the line of the array's key
+	key = arrayIndexRegex.ReplaceAllString(key, "")
+	parts := strings.Split(strings.ToLower(key), ".")
+	for curLen := len(parts); curLen > 0; curLen-- {
+		if line, found := lines[strings.Join(parts[:curLen], ".")]; found {
+			return line
+		}
+	}
+
+	return 0
+}
+
+// getKeyLines returns the line each table and key in the TOML content is defined on. Keys are
+// lowercased since they are matched to struct fields without regard to case.
+func getKeyLines(content string) map[string]int {
+	lines := map[string]int{}
+	table := ""
+	inMultilineString := false
+	for curIndex, curLine := range strings.Split(content, "\n") {
+		lineNumber := curIndex + 1
+		trimmedLine := strings.TrimSpace(curLine)
+		if inMultilineString {
+			if strings.Count(trimmedLine, `"""`)%2 == 1 || strings.Count(trimmedLine, `'''`)%2 == 1 {
+				inMultilineString = false
+			}
+			continue
+		}
+
+		if match := tableHeaderRegex.FindStringSubmatch(trimmedLine); match != nil {
+			table = normaliseKey(match[1])
+			if _, seen := lines[table]; !seen {
+				lines[table] = lineNumber
+			}
+			continue
+		}
+		if match := keyValueRegex.FindStringSubmatch(trimmedLine); match != nil {
+			key := normaliseKey(match[1])
+			if len(table) > 0 {
+				key = table + "." + key
+			}
+			if _, seen := lines[key]; !seen {
+				lines[key] = lineNumber
+			}
+			if strings.Count(trimmedLine, `"""`) == 1 || strings.Count(trimmedLine, `'''`) == 1 {
+				inMultilineString = true
+			}
+		}
+	}
+
+	return lines
+}
+
+func normaliseKey(key string) string {
+	parts := strings.Split(key, ".")
+	for curIndex, curPart := range parts {
+		parts[curIndex] = strings.Trim(strings.TrimSpace(curPart), `"'`)
+	}
+
+	return strings.ToLower(strings.Join(parts, "."))
+}
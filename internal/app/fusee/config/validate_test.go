@@ -0,0 +1,93 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateMountOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		mount    Mount
+		expected string
+	}{
+		{"no options", Mount{}, ""},
+		{"valid options", Mount{FsName: "vault", Subtype: "secrets", EntryTimeoutSeconds: 1, AttrTimeoutSeconds: 1.5, NegativeTimeoutSeconds: 2, MaxRead: 4096, MaxReadAhead: 4096}, ""},
+		{"fsName with comma", Mount{FsName: "a,b"}, "fsName cannot contain commas or spaces"},
+		{"subtype with space", Mount{Subtype: "a b"}, "subtype cannot contain commas or spaces"},
+		{"negative entry timeout", Mount{EntryTimeoutSeconds: -1}, "entryTimeoutSeconds cannot be negative"},
+		{"negative attr timeout", Mount{AttrTimeoutSeconds: -0.5}, "attrTimeoutSeconds cannot be negative"},
+		{"negative negative timeout", Mount{NegativeTimeoutSeconds: -1}, "negativeTimeoutSeconds cannot be negative"},
+		{"negative maxRead", Mount{MaxRead: -1}, "maxRead should be between 0 and 131072"},
+		{"maxRead too large", Mount{MaxRead: maxReadSize + 1}, "maxRead should be between 0 and 131072"},
+		{"negative maxReadAhead", Mount{MaxReadAhead: -1}, "maxReadAhead cannot be negative"},
+		{"several problems", Mount{EntryTimeoutSeconds: -1, MaxRead: -1}, "entryTimeoutSeconds cannot be negative; maxRead should be between 0 and 131072"},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			validateErr := ValidateMountOptions(curTest.mount)
+			if len(curTest.expected) == 0 {
+				if validateErr != nil {
+					t.Errorf("Expected no error, got '%v'", validateErr)
+				}
+				return
+			}
+			if validateErr == nil || validateErr.Error() != curTest.expected {
+				t.Errorf("Expected error '%s', got '%v'", curTest.expected, validateErr)
+			}
+		})
+	}
+}
+
+func TestValidateMount(t *testing.T) {
+	tests := []struct {
+		name         string
+		change       func(mount *Mount)
+		expectedKeys []string
+	}{
+		{"valid text listing", func(mount *Mount) {}, nil},
+		{"missing read commands", func(mount *Mount) {
+			mount.ReadCommand = ""
+			mount.NameSeparator = ""
+			mount.File.ReadCommand = ""
+		}, []string{"readCommand", "nameSeparator", "file.readCommand"}},
+		{"JSON listing without name separator", func(mount *Mount) {
+			mount.ListFormat = "json"
+			mount.NameSeparator = ""
+		}, nil},
+		{"unknown list format", func(mount *Mount) { mount.ListFormat = "yaml" }, []string{"listFormat"}},
+		{"template that does not parse", func(mount *Mount) { mount.ReadCommand = "ls {{ .RelativePath" }, []string{"readCommand"}},
+		{"unset mode", func(mount *Mount) { mount.File.Mode = 0 }, []string{"file.mode"}},
+		{"coprocess with read command", func(mount *Mount) {
+			mount.Coprocess = []string{"helper"}
+			mount.File.ReadCommand = ""
+			mount.Directory.Mode = 0o555
+		}, []string{"readCommand"}},
+		{"keepCache without cache", func(mount *Mount) { mount.File.KeepCache = true }, []string{"file.keepCache"}},
+		{"keepCache with cache", func(mount *Mount) {
+			mount.File.KeepCache = true
+			mount.File.Cache = true
+		}, nil},
+		{"invalid mount option", func(mount *Mount) { mount.MaxRead = -1 }, []string{"maxRead"}},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			mount := Mount{
+				Path:          t.TempDir(),
+				ReadCommand:   "ls",
+				NameSeparator: "\n",
+				Mode:          0o555,
+				File:          File{ReadCommand: "cat {{ shellquote .RelativePath }}", Mode: 0o444},
+			}
+			curTest.change(&mount)
+			problems := validateMount(mount)
+			keys := []string{}
+			for _, curProblem := range problems {
+				keys = append(keys, curProblem.key)
+			}
+			if strings.Join(keys, ",") != strings.Join(curTest.expectedKeys, ",") {
+				t.Errorf("Expected problems with %v, got %v", curTest.expectedKeys, problems)
+			}
+		})
+	}
+}
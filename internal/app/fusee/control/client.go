@@ -0,0 +1,34 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+)
+
+// Send sends the request to the control socket at socketPath and returns the result.
+func Send(socketPath string, request Request) (json.RawMessage, error) {
+	conn, dialErr := net.Dial("unix", socketPath)
+	if dialErr != nil {
+		return nil, dialErr
+	}
+	defer conn.Close()
+
+	if encodeErr := json.NewEncoder(conn).Encode(request); encodeErr != nil {
+		return nil, encodeErr
+	}
+	responseLine, readErr := bufio.NewReader(conn).ReadBytes('\n')
+	if readErr != nil {
+		return nil, readErr
+	}
+	response := Response{}
+	if decodeErr := json.Unmarshal(responseLine, &response); decodeErr != nil {
+		return nil, decodeErr
+	}
+	if !response.OK {
+		return nil, errors.New(response.Error)
+	}
+
+	return response.Result, nil
+}
@@ -0,0 +1,26 @@
+package control
+
+import "encoding/json"
+
+// The commands accepted by the control socket.
+const (
+	CommandList       = "list"
+	CommandStats      = "stats"
+	CommandTree       = "tree"
+	CommandInvalidate = "invalidate"
+)
+
+// Request is sent, as a single line of JSON, by a client to the control socket.
+type Request struct {
+	Command string `json:"command"`
+	Mount   string `json:"mount,omitempty"`
+	// The path, relative to the mount's root, the command should act on
+	Path string `json:"path,omitempty"`
+}
+
+// Response is sent, as a single line of JSON, by the control socket for every request it gets.
+type Response struct {
+	OK     bool            `json:"ok"`
+	Error  string          `json:"error,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
@@ -0,0 +1,117 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/jasonrogena/fusee/internal/app/fusee/supervisor"
+	log "github.com/sirupsen/logrus"
+)
+
+// Server accepts requests over a Unix domain socket for inspecting and managing the mounts of a
+// supervisor.
+type Server struct {
+	socketPath string
+	supervisor *supervisor.Supervisor
+	listener   net.Listener
+}
+
+func NewServer(socketPath string, mountSupervisor *supervisor.Supervisor) *Server {
+	return &Server{
+		socketPath: socketPath,
+		supervisor: mountSupervisor,
+	}
+}
+
+// Start starts listening on the socket. The socket is only accessible by the user running
+// fusee. Requests are served in the background.
+func (s *Server) Start() error {
+	// Remove the socket left behind if fusee was not shut down cleanly
+	if removeErr := os.Remove(s.socketPath); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+		return removeErr
+	}
+	// Create the socket with the permissions 0600 instead of changing them once it is created, which
+	// would leave other users a window to connect. The umask is process wide so this is done before
+	// the mounts are started
+	oldUmask := syscall.Umask(0o177)
+	listener, listenErr := net.Listen("unix", s.socketPath)
+	syscall.Umask(oldUmask)
+	if listenErr != nil {
+		return listenErr
+	}
+	s.listener = listener
+	log.Info(fmt.Sprintf("Listening for control requests on '%s'", s.socketPath))
+
+	go func() {
+		for {
+			conn, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				if !errors.Is(acceptErr, net.ErrClosed) {
+					log.Error(fmt.Sprintf("Unable to accept control connection: %v", acceptErr))
+				}
+				return
+			}
+			go s.serveConnection(conn)
+		}
+	}()
+
+	return nil
+}
+
+// Stop stops listening on the socket and removes it.
+func (s *Server) Stop() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+}
+
+func (s *Server) serveConnection(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		request := Request{}
+		var response Response
+		if decodeErr := json.Unmarshal(scanner.Bytes(), &request); decodeErr != nil {
+			response = Response{Error: fmt.Sprintf("Unable to decode request: %v", decodeErr)}
+		} else {
+			response = s.handleRequest(request)
+		}
+		if encodeErr := encoder.Encode(response); encodeErr != nil {
+			log.Warn(fmt.Sprintf("Unable to send control response: %v", encodeErr))
+			return
+		}
+	}
+}
+
+func (s *Server) handleRequest(request Request) Response {
+	log.Debug(fmt.Sprintf("Handling control request '%s'", request.Command))
+	var result interface{}
+	var resultErr error
+	switch request.Command {
+	case CommandList:
+		result = s.supervisor.GetMounts()
+	case CommandStats:
+		result, resultErr = s.supervisor.GetCommandStats(request.Mount)
+	case CommandTree:
+		result, resultErr = s.supervisor.GetTree(request.Mount)
+	case CommandInvalidate:
+		resultErr = s.supervisor.Invalidate(request.Mount, request.Path)
+	default:
+		resultErr = fmt.Errorf("Unknown command '%s'", request.Command)
+	}
+	if resultErr != nil {
+		return Response{Error: resultErr.Error()}
+	}
+
+	encodedResult, encodeErr := json.Marshal(result)
+	if encodeErr != nil {
+		return Response{Error: encodeErr.Error()}
+	}
+	return Response{OK: true, Result: encodedResult}
+}
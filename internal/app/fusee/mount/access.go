@@ -0,0 +1,117 @@
+package mount
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/jasonrogena/fusee/internal/app/fusee/config"
+	"github.com/jasonrogena/fusee/internal/pkg/audit"
+	"github.com/jasonrogena/fusee/internal/pkg/command"
+	fuseefs "github.com/jasonrogena/fusee/internal/pkg/fs"
+	log "github.com/sirupsen/logrus"
+)
+
+// checkAccess checks whether the caller of the FUSE request in ctx is allowed to carry out the
+// operation on the node in commandState by the mount's access policy and, if not nil, the file's
+// access policy. Returns EACCES, and logs the denial, if the caller is not allowed. Callers whose
+// executable or command line cannot be read, for instance because the kernel did not give their
+// PID or they have exited, are not allowed by policies matching either. Requests not made through
+// FUSE, for instance when rendering a mount, are always allowed.
+func checkAccess(ctx context.Context, s *settings, commandState *command.State, operation string, fileAccess *config.Access) syscall.Errno {
+	policies := []config.Access{}
+	if mountAccess := s.getMountConfig().Access; mountAccess.IsSet() {
+		policies = append(policies, mountAccess)
+	}
+	if fileAccess != nil && fileAccess.IsSet() {
+		policies = append(policies, *fileAccess)
+	}
+	caller, hasCaller := fuse.FromContext(ctx)
+	if len(policies) == 0 || !hasCaller {
+		return 0
+	}
+
+	exe := ""
+	cmdline := ""
+	if caller.Pid != 0 {
+		exe = fuseefs.GetProcessExe(caller.Pid)
+	}
+	for _, curPolicy := range policies {
+		if len(curPolicy.Cmdlines) > 0 && len(cmdline) == 0 && caller.Pid != 0 {
+			cmdline = fuseefs.GetProcessCmdline(caller.Pid)
+		}
+		if reason := getDenialReason(curPolicy, caller, exe, cmdline); len(reason) > 0 {
+			log.Warn(fmt.Sprintf("Access denied: mount='%s' path='%s' operation=%s uid=%d gid=%d pid=%d exe='%s' reason='%s'",
+				commandState.MountName, commandState.RelativePath, operation, caller.Uid, caller.Gid, caller.Pid, exe, reason))
+			audit.RecordDenial(audit.Denial{
+				Mount:     commandState.MountName,
+				Path:      commandState.RelativePath,
+				Operation: operation,
+				CallerUID: caller.Uid,
+				CallerGID: caller.Gid,
+				CallerPID: caller.Pid,
+				CallerExe: exe,
+				Reason:    reason,
+			})
+			return syscall.EACCES
+		}
+	}
+
+	return 0
+}
+
+// getDenialReason returns why the policy does not allow the caller. Returns a blank string if the
+// caller is allowed.
+func getDenialReason(policy config.Access, caller *fuse.Caller, exe string, cmdline string) string {
+	if len(policy.UIDs) > 0 && !containsID(policy.UIDs, caller.Uid) {
+		return "uid not allowed"
+	}
+	if len(policy.GIDs) > 0 && !containsID(policy.GIDs, caller.Gid) {
+		return "gid not allowed"
+	}
+	if len(policy.Exes) > 0 && len(exe) == 0 {
+		return "executable unknown"
+	}
+	if len(policy.Exes) > 0 && !matchesAnyGlob(policy.Exes, exe) {
+		return "executable not allowed"
+	}
+	if len(policy.Cmdlines) > 0 && len(cmdline) == 0 {
+		return "command line unknown"
+	}
+	if len(policy.Cmdlines) > 0 && !matchesAnyGlob(policy.Cmdlines, cmdline) {
+		return "command line not allowed"
+	}
+
+	return ""
+}
+
+func containsID(ids []uint32, id uint32) bool {
+	for _, curID := range ids {
+		if curID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesAnyGlob returns true if text matches any of the glob patterns. In the patterns, * matches
+// any sequence of characters, including /, and ? matches any single character.
+func matchesAnyGlob(patterns []string, text string) bool {
+	if len(text) == 0 {
+		return false
+	}
+	for _, curPattern := range patterns {
+		expression := regexp.QuoteMeta(curPattern)
+		expression = strings.ReplaceAll(expression, `\*`, ".*")
+		expression = strings.ReplaceAll(expression, `\?`, ".")
+		if regexp.MustCompile("^" + expression + "$").MatchString(text) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,146 @@
+package mount
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/jasonrogena/fusee/internal/app/fusee/config"
+	"github.com/jasonrogena/fusee/internal/pkg/command"
+)
+
+// getExitedPid returns the PID of a process that has already exited.
+func getExitedPid(t *testing.T) uint32 {
+	t.Helper()
+	cmd := exec.Command("true")
+	if runErr := cmd.Run(); runErr != nil {
+		t.Fatal(runErr)
+	}
+	return uint32(cmd.Process.Pid)
+}
+
+func TestCheckAccess(t *testing.T) {
+	exe, exeErr := os.Executable()
+	if exeErr != nil {
+		t.Fatal(exeErr)
+	}
+	uid := uint32(1000)
+	ownPid := uint32(os.Getpid())
+	exitedPid := getExitedPid(t)
+	tests := []struct {
+		name        string
+		mountAccess config.Access
+		fileAccess  *config.Access
+		hasCaller   bool
+		pid         uint32
+		expected    syscall.Errno
+	}{
+		{"no policy", config.Access{}, nil, true, ownPid, 0},
+		{"no caller", config.Access{UIDs: []uint32{0}}, nil, false, 0, 0},
+		{"uid allowed", config.Access{UIDs: []uint32{uid}}, nil, true, ownPid, 0},
+		{"uid denied", config.Access{UIDs: []uint32{0}}, nil, true, ownPid, syscall.EACCES},
+		{"gid denied", config.Access{GIDs: []uint32{0}}, nil, true, ownPid, syscall.EACCES},
+		{"uid denied without pid", config.Access{UIDs: []uint32{0}}, nil, true, 0, syscall.EACCES},
+		{"uid allowed without pid", config.Access{UIDs: []uint32{uid}}, nil, true, 0, 0},
+		{"exe allowed", config.Access{Exes: []string{exe}}, nil, true, ownPid, 0},
+		{"exe glob allowed", config.Access{Exes: []string{"/*"}}, nil, true, ownPid, 0},
+		{"exe denied", config.Access{Exes: []string{"/usr/bin/gpg"}}, nil, true, ownPid, syscall.EACCES},
+		{"exe without pid", config.Access{Exes: []string{"*"}}, nil, true, 0, syscall.EACCES},
+		{"exe of exited process", config.Access{Exes: []string{"*"}}, nil, true, exitedPid, syscall.EACCES},
+		{"cmdline allowed", config.Access{Cmdlines: []string{"*-test.*"}}, nil, true, ownPid, 0},
+		{"cmdline denied", config.Access{Cmdlines: []string{"ansible-playbook *"}}, nil, true, ownPid, syscall.EACCES},
+		{"cmdline without pid", config.Access{Cmdlines: []string{"*"}}, nil, true, 0, syscall.EACCES},
+		{"cmdline of exited process", config.Access{Cmdlines: []string{"*"}}, nil, true, exitedPid, syscall.EACCES},
+		{"file policy allowed", config.Access{}, &config.Access{UIDs: []uint32{uid}}, true, ownPid, 0},
+		{"file policy denied", config.Access{}, &config.Access{Exes: []string{"/usr/bin/gpg"}}, true, ownPid, syscall.EACCES},
+		{"mount allows but file denies", config.Access{UIDs: []uint32{uid}}, &config.Access{UIDs: []uint32{0}}, true, ownPid, syscall.EACCES},
+		{"mount denies but file allows", config.Access{UIDs: []uint32{0}}, &config.Access{UIDs: []uint32{uid}}, true, ownPid, syscall.EACCES},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			mountSettings, settingsErr := newSettings("test", config.Mount{Path: "/mnt", Access: curTest.mountAccess}, nil)
+			if settingsErr != nil {
+				t.Fatal(settingsErr)
+			}
+			ctx := context.Background()
+			if curTest.hasCaller {
+				ctx = fuse.NewContext(ctx, &fuse.Caller{Owner: fuse.Owner{Uid: uid, Gid: uid}, Pid: curTest.pid})
+			}
+			commandState := command.NewState("test", "/mnt", "file", "file")
+			if errno := checkAccess(ctx, mountSettings, commandState, "Open", curTest.fileAccess); errno != curTest.expected {
+				t.Errorf("Expected %v, got %v", curTest.expected, errno)
+			}
+		})
+	}
+}
+
+func TestMatchesAnyGlob(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		text     string
+		expected bool
+	}{
+		{[]string{"/usr/bin/python3*"}, "/usr/bin/python3.10", true},
+		{[]string{"/usr/bin/python3*"}, "/usr/bin/python2.7", false},
+		{[]string{"/usr/*"}, "/usr/local/bin/gpg", true},
+		{[]string{"/usr/bin/gp?"}, "/usr/bin/gpg", true},
+		{[]string{"/usr/bin/gp?"}, "/usr/bin/gpg2", false},
+		{[]string{"/bin/sh", "/usr/bin/gpg"}, "/usr/bin/gpg", true},
+		{[]string{"*.[ch]"}, "file.c", false},
+		{[]string{"*.[ch]"}, "file.[ch]", true},
+		{[]string{"*"}, "", false},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.text, func(t *testing.T) {
+			if matches := matchesAnyGlob(curTest.patterns, curTest.text); matches != curTest.expected {
+				t.Errorf("Expected %t for %v, got %t", curTest.expected, curTest.patterns, matches)
+			}
+		})
+	}
+}
+
+// TestXattrAccess checks that the extended attributes exposing the results of commands are only
+// available to the callers the access policies allow.
+func TestXattrAccess(t *testing.T) {
+	listing := writeTestScript(t, "echo '[{\"name\": \"file\", \"type\": \"file\"}, {\"name\": \"dir\", \"type\": \"dir\"}]'\n")
+	r := newTestRoot(t, config.Mount{
+		Path:        t.TempDir(),
+		ReadCommand: "sh " + listing,
+		ListFormat:  listFormatJSON,
+		Mode:        0o555,
+		ThreadCount: 2,
+		Access:      config.Access{UIDs: []uint32{1000}},
+		Directory:   config.Directory{Mode: 0o555, ReadCommand: "true", ListFormat: listFormatJSON},
+		File:        config.File{Mode: 0o444, ReadCommand: "true"},
+	})
+	nodes := map[string]fs.InodeEmbedder{"root": r}
+	for _, curName := range []string{"file", "dir"} {
+		child, errno := r.Lookup(context.Background(), curName, &fuse.EntryOut{})
+		if errno != 0 {
+			t.Fatalf("Unable to look up '%s': %v", curName, errno)
+		}
+		nodes[curName] = child.Operations()
+	}
+	for curName, curNode := range nodes {
+		t.Run(curName, func(t *testing.T) {
+			for _, curTest := range []struct {
+				uid    uint32
+				denied bool
+			}{
+				{1000, false},
+				{1001, true},
+			} {
+				ctx := newCallerContext(curTest.uid)
+				_, getErrno := curNode.(fs.NodeGetxattrer).Getxattr(ctx, exitStatusXattr, make([]byte, 64))
+				_, listErrno := curNode.(fs.NodeListxattrer).Listxattr(ctx, make([]byte, 64))
+				if (getErrno == syscall.EACCES) != curTest.denied || (listErrno == syscall.EACCES) != curTest.denied {
+					t.Errorf("Expected %d to be denied: %t, got %v and %v", curTest.uid, curTest.denied, getErrno, listErrno)
+				}
+			}
+		})
+	}
+}
@@ -16,8 +16,7 @@ import (
 
 type directory struct {
 	fs.Inode
-	dirConfig         config.Directory
-	fileConfig        config.File
+	settings          *settings
 	commandState      *command.State
 	attr              *fuse.Attr
 	dirEntries        []fuse.DirEntry
@@ -28,46 +27,63 @@ type directory struct {
 	// We cache the output from the test so that incase ReadDir is called against this directory
 	// before its atime expires we just build its dirents using the cached test run output.
 	cachedTestRunOutput []byte
+	lastCommand         *commandResult
+	// Attributes set by the JSON listing the directory was in. Updated when the directory is
+	// listed again
+	entryAttr      entryAttr
+	entryAttrMutex *sync.Mutex
+	// When the content was last loaded. Zero if it has not been loaded since the node was added or
+	// invalidated
+	loadedAt time.Time
 }
 
-func NewDirectory(dirConfig config.Directory, fileConfig config.File, cachedTestRunOutput []byte, commandState *command.State, commandRunnerPool *command.Pool) *directory {
+func NewDirectory(settings *settings, cachedTestRunOutput []byte, commandState *command.State, commandRunnerPool *command.Pool) *directory {
 	return &directory{
 		commandState:        commandState,
-		dirConfig:           dirConfig,
-		fileConfig:          fileConfig,
+		settings:            settings,
 		commandRunnerPool:   commandRunnerPool,
 		cachedTestRunOutput: cachedTestRunOutput,
+		lastCommand:         newCommandResult(),
+		entryAttrMutex:      new(sync.Mutex),
 	}
 }
 
-func (d *directory) getDirectoryConfig() config.Directory {
-	return d.dirConfig
+func (d *directory) getSettings() *settings {
+	return d.settings
 }
 
-func (d *directory) getFileConfig() config.File {
-	return d.fileConfig
+func (d *directory) getDirectoryConfig() config.Directory {
+	return d.settings.getDirectoryConfig()
 }
 
 func (d *directory) getInode() *fs.Inode {
 	return &d.Inode
 }
 
-func (d *directory) getReadCommand() (string, error) {
-	if len(d.dirConfig.ReadCommand) > 0 {
-		return d.dirConfig.ReadCommand, nil
+func (d *directory) getReadCommand() (command.Template, error) {
+	if dirTemplate := d.settings.getTemplates().directory; dirTemplate.IsSet() {
+		return dirTemplate, nil
 	}
 
-	return "", errors.New("Read command not provided for directory")
+	return command.Template{}, errors.New("Read command not provided for directory")
 }
 
 func (d *directory) getNameSeparator() (string, error) {
-	if len(d.dirConfig.NameSeparator) > 0 {
-		return d.dirConfig.NameSeparator, nil
+	if d.settings.hasBackend() {
+		return command.BackendNameSeparator, nil
+	}
+	dirConfig := d.getDirectoryConfig()
+	if len(dirConfig.NameSeparator) > 0 {
+		return dirConfig.NameSeparator, nil
 	}
 
 	return "", errors.New("Name separator not provided for directory")
 }
 
+func (d *directory) getTimeout() time.Duration {
+	return getTimeout(d.getDirectoryConfig().TimeoutSeconds)
+}
+
 func (d *directory) getCommandState() *command.State {
 	return d.commandState
 }
@@ -91,10 +107,29 @@ func (d *directory) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.Att
 }
 
 func (d *directory) getattr(out *fuse.AttrOut) {
-	out.Mode = d.dirConfig.Mode
+	out.Mode = d.getDirectoryConfig().Mode
 	out.Mtime = d.attr.Mtime
 	out.Ctime = d.attr.Ctime
 	out.Atime = d.attr.Atime
+	d.getEntryAttr().apply(out)
+}
+
+func (d *directory) getLastCommand() *commandResult {
+	return d.lastCommand
+}
+
+func (d *directory) Getxattr(ctx context.Context, attr string, dest []byte) (uint32, syscall.Errno) {
+	if errno := checkAccess(ctx, d.settings, d.commandState, "Getxattr", nil); errno != 0 {
+		return 0, errno
+	}
+	return getResultXattr(d.lastCommand, attr, dest)
+}
+
+func (d *directory) Listxattr(ctx context.Context, dest []byte) (uint32, syscall.Errno) {
+	if errno := checkAccess(ctx, d.settings, d.commandState, "Listxattr", nil); errno != 0 {
+		return 0, errno
+	}
+	return listResultXattrs(d.lastCommand, dest)
 }
 
 func (d *directory) HasNext() bool {
@@ -119,16 +154,20 @@ func (d *directory) getChildren() map[string]*fs.Inode {
 
 func (d *directory) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
 	log.Debug("Lookup called for directory")
-	return lookupChild(ctx, d, name)
+	defer observeOperation(d.commandState.MountName, "Lookup", time.Now())
+	return lookupChild(ctx, d, name, out)
 }
 
 func (d *directory) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
 	log.Debug("Readdir called for directory")
-	var wg sync.WaitGroup
-	loadErr := loadChildren(ctx, d, &wg)
-	wg.Wait()
+	defer observeOperation(d.commandState.MountName, "Readdir", time.Now())
+	if errno := checkAccess(ctx, d.settings, d.commandState, "Readdir", nil); errno != 0 {
+		return nil, errno
+	}
+	loadErr := loadChildren(ctx, d)
 	if loadErr != nil {
 		log.Error(loadErr.Error())
+		return nil, getFailureErrno(loadErr, d.getDirectoryConfig().ExitCodeErrno)
 	}
 	d.attr.Atime = uint64(time.Now().Unix())
 	for childName, chidInode := range d.Children() {
@@ -147,27 +186,70 @@ func (d *directory) getAttr() *fuse.Attr {
 
 func (d *directory) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
 	log.Debug("Open called for directory")
-	var wg sync.WaitGroup
-	loadErr := loadChildren(ctx, d, &wg)
-	wg.Wait()
+	defer observeOperation(d.commandState.MountName, "Open", time.Now())
+	loadErr := loadChildren(ctx, d)
 	if loadErr != nil {
 		log.Error(loadErr.Error())
+		return nil, 0, getFailureErrno(loadErr, d.getDirectoryConfig().ExitCodeErrno)
 	}
 	return d, fuse.FOPEN_DIRECT_IO, 0
 }
 
+func (d *directory) getEntryAttr() entryAttr {
+	d.entryAttrMutex.Lock()
+	defer d.entryAttrMutex.Unlock()
+	return d.entryAttr
+}
+
+func (d *directory) setEntryAttr(attr entryAttr) {
+	d.entryAttrMutex.Lock()
+	defer d.entryAttrMutex.Unlock()
+	d.entryAttr = attr
+}
+
 func (d *directory) getCacheSeconds() uint64 {
-	return d.dirConfig.CacheSeconds
+	if cacheSeconds := d.getEntryAttr().cacheSeconds; cacheSeconds != nil {
+		return *cacheSeconds
+	}
+	return d.getDirectoryConfig().CacheSeconds
 }
 
 func (d *directory) shouldCache() bool {
-	return d.dirConfig.Cache
+	if cacheSeconds := d.getEntryAttr().cacheSeconds; cacheSeconds != nil {
+		return *cacheSeconds > 0
+	}
+	return d.getDirectoryConfig().Cache
+}
+
+// getListFormat returns the format the directory's read command lists entries in.
+func (d *directory) getListFormat() string {
+	if d.settings.hasBackend() {
+		return listFormatText
+	}
+	return getListFormat(d.getDirectoryConfig().ListFormat)
 }
 
 func (d *directory) isContentStale() bool {
+	// Listings that depend on the caller are not cached since the tree is shared by all callers
+	if d.settings.getTemplates().directory.UsesCaller() {
+		return true
+	}
 	return isContentStale(d)
 }
 
+func (d *directory) getLoadedAt() time.Time {
+	return d.loadedAt
+}
+
+func (d *directory) setLoadedAt(loadedAt time.Time) {
+	d.loadedAt = loadedAt
+}
+
+func (d *directory) invalidate() {
+	invalidate(d)
+	d.setCachedTestRunOutput([]byte{})
+}
+
 func (d *directory) OnAdd(ctx context.Context) {
 	log.Debug("OnAdd called on directory")
 	curTime := time.Now()
@@ -178,9 +260,11 @@ func (d *directory) OnAdd(ctx context.Context) {
 }
 
 var _ = (fs.InodeEmbedder)((*directory)(nil))
-var _ = (fs.NodeGetattrer)((*directory)(nil)) // Contains Getattr
-var _ = (fs.DirStream)((*directory)(nil))     // Contains HasNext, Next, and Close
-var _ = (fs.NodeLookuper)((*directory)(nil))  // Contains Lookup
-var _ = (fs.NodeReaddirer)((*directory)(nil)) // Contains Readdir
-var _ = (fs.NodeOpener)((*directory)(nil))    // Contains Open
-var _ = (fs.NodeOnAdder)((*directory)(nil))   // Contains OnAdd
+var _ = (fs.NodeGetattrer)((*directory)(nil))   // Contains Getattr
+var _ = (fs.DirStream)((*directory)(nil))       // Contains HasNext, Next, and Close
+var _ = (fs.NodeLookuper)((*directory)(nil))    // Contains Lookup
+var _ = (fs.NodeReaddirer)((*directory)(nil))   // Contains Readdir
+var _ = (fs.NodeOpener)((*directory)(nil))      // Contains Open
+var _ = (fs.NodeOnAdder)((*directory)(nil))     // Contains OnAdd
+var _ = (fs.NodeGetxattrer)((*directory)(nil))  // Contains Getxattr
+var _ = (fs.NodeListxattrer)((*directory)(nil)) // Contains Listxattr
@@ -1,7 +1,9 @@
 package mount
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"os"
 	"sync"
 	"syscall"
@@ -11,70 +13,196 @@ import (
 	"github.com/hanwen/go-fuse/v2/fuse"
 	"github.com/jasonrogena/fusee/internal/app/fusee/config"
 	"github.com/jasonrogena/fusee/internal/pkg/command"
+	fuseefs "github.com/jasonrogena/fusee/internal/pkg/fs"
 	log "github.com/sirupsen/logrus"
 )
 
 type file struct {
 	fs.Inode
-	config            config.File
+	settings          *settings
 	commandState      *command.State
 	attr              *fuse.Attr
 	content           []byte
 	commandRunnerPool *command.Pool
+	lastCommand       *commandResult
+	// Attributes set by the JSON listing the file was in. Updated when the file is listed again
+	entryAttr      entryAttr
+	entryAttrMutex *sync.Mutex
+	// Content loaded using a read command that uses caller variables, keyed by caller
+	callerContents      map[string]*callerContent
+	callerContentsMutex *sync.Mutex
+	// When the content was last loaded. Zero if it has not been loaded since the node was added or
+	// invalidated
+	loadedAt time.Time
 }
 
-func NewFile(config config.File, commandState *command.State, commandRunnerPool *command.Pool) *file {
+// callerContent is the content of a file loaded for a caller.
+type callerContent struct {
+	content  []byte
+	loadedAt time.Time
+}
+
+// contentHandle is the handle of a file opened with content loaded for a single caller.
+type contentHandle struct {
+	mountName string
+	content   []byte
+}
+
+func NewFile(settings *settings, commandState *command.State, commandRunnerPool *command.Pool) *file {
 	return &file{
-		config:            config,
-		commandState:      commandState,
-		commandRunnerPool: commandRunnerPool,
+		settings:            settings,
+		commandState:        commandState,
+		commandRunnerPool:   commandRunnerPool,
+		lastCommand:         newCommandResult(),
+		entryAttrMutex:      new(sync.Mutex),
+		callerContents:      map[string]*callerContent{},
+		callerContentsMutex: new(sync.Mutex),
 	}
 }
 
 func (f *file) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
 	log.Debug("Read called on file")
-	end := off + int64(len(dest))
+	f.attr.Atime = uint64(time.Now().Unix())
+	return readContent(f.commandState.MountName, f.content, dest, off), 0
+}
 
-	if end > int64(len(f.content)) {
-		end = int64(len(f.content))
+func (h *contentHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	log.Debug("Read called on caller's file handle")
+	return readContent(h.mountName, h.content, dest, off), 0
+}
+
+func readContent(mountName string, content []byte, dest []byte, off int64) fuse.ReadResult {
+	defer observeOperation(mountName, "Read", time.Now())
+	end := off + int64(len(dest))
+	if end > int64(len(content)) {
+		end = int64(len(content))
+	}
+	if off > end {
+		off = end
 	}
 
-	f.attr.Atime = uint64(time.Now().Unix())
-	return fuse.ReadResultData(f.content[off:end]), 0
+	bytesServedTotal.Add(float64(end-off), mountName)
+	return fuse.ReadResultData(content[off:end])
 }
 
 func (f *file) Open(ctx context.Context, openFlags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
 	log.Debug("Open called for file")
-	if isContentStale(f) {
-		var wg sync.WaitGroup
-		wg.Add(1)
-		log.Info("Running command to get contents for ",
-			f.commandState.MountRootDirPath+string(os.PathSeparator)+f.commandState.RelativePath)
-		f.commandRunnerPool.AddCommand(command.NewCommand(f.config.ReadCommand, f.commandState, func(output []byte, outputErr error) {
-			if outputErr != nil {
-				log.Error(outputErr.Error())
+	defer observeOperation(f.commandState.MountName, "Open", time.Now())
+	fileAccess := f.getFileConfig().Access
+	if errno := checkAccess(ctx, f.settings, f.commandState, "Open", &fileAccess); errno != 0 {
+		return nil, 0, errno
+	}
+	commandState := fuseefs.WithCaller(ctx, f.commandState)
+	if callerKey := f.settings.getTemplates().file.GetCallerKey(commandState); len(callerKey) > 0 {
+		return f.openForCaller(ctx, commandState, callerKey)
+	}
+
+	isStale := isContentStale(f)
+	observeCacheRequest(f.commandState.MountName, "file", !isStale)
+	hasChanged := false
+	if isStale {
+		output, readErr := f.runReadCommand(ctx, commandState)
+		if readErr != nil {
+			return nil, 0, getFailureErrno(readErr, f.getFileConfig().ExitCodeErrno)
+		}
+		hasChanged = !bytes.Equal(f.content, output)
+		f.content = output
+		markLoaded(f)
+		if hasChanged {
+			notifyKernel(f.settings, f.commandState.RelativePath, func(name string) syscall.Errno {
+				return f.NotifyContent(0, 0)
+			})
+		}
+	}
+
+	return f, f.getOpenFlags(hasChanged), 0
+}
+
+// getOpenFlags returns the flags the file is opened with. Files are read directly from fusee unless
+// keepCache is set, in which case the kernel keeps the file's content cached until it changes.
+func (f *file) getOpenFlags(hasChanged bool) uint32 {
+	if !f.shouldKeepCache() {
+		return fuse.FOPEN_DIRECT_IO
+	}
+	if hasChanged {
+		// The kernel drops the content it cached when a file is opened without FOPEN_KEEP_CACHE
+		return 0
+	}
+
+	return fuse.FOPEN_KEEP_CACHE
+}
+
+// openForCaller opens the file with content loaded for the caller in commandState. Content is
+// cached separately for each caller key.
+func (f *file) openForCaller(ctx context.Context, commandState *command.State, callerKey string) (fs.FileHandle, uint32, syscall.Errno) {
+	f.callerContentsMutex.Lock()
+	cached, found := f.callerContents[callerKey]
+	f.callerContentsMutex.Unlock()
+	isStale := !found || !f.isCallerContentFresh(cached)
+	observeCacheRequest(f.commandState.MountName, "file", !isStale)
+	if isStale {
+		output, readErr := f.runReadCommand(ctx, commandState)
+		if readErr != nil {
+			return nil, 0, getFailureErrno(readErr, f.getFileConfig().ExitCodeErrno)
+		}
+		cached = &callerContent{content: output, loadedAt: time.Now()}
+		f.callerContentsMutex.Lock()
+		for curKey, curContent := range f.callerContents {
+			if !f.isCallerContentFresh(curContent) {
+				delete(f.callerContents, curKey)
 			}
-			f.content = output
-			f.attr.Mtime = uint64(time.Now().Unix())
-			wg.Done()
-		}))
-		wg.Wait()
+		}
+		if f.shouldCache() {
+			f.callerContents[callerKey] = cached
+		}
+		f.callerContentsMutex.Unlock()
+	}
+
+	return &contentHandle{mountName: f.commandState.MountName, content: cached.content}, fuse.FOPEN_DIRECT_IO, 0
+}
+
+func (f *file) isCallerContentFresh(cached *callerContent) bool {
+	return f.shouldCache() && time.Since(cached.loadedAt) <= time.Duration(f.getCacheSeconds())*time.Second
+}
+
+// runReadCommand runs the file's read command and returns its output.
+func (f *file) runReadCommand(ctx context.Context, commandState *command.State) ([]byte, error) {
+	var wg sync.WaitGroup
+	var output []byte
+	var readErr error
+	wg.Add(1)
+	log.Info("Running command to get contents for ",
+		f.commandState.MountRootDirPath+string(os.PathSeparator)+f.commandState.RelativePath)
+	f.commandRunnerPool.AddCommand(command.NewCommand(ctx, command.KindRead, f.settings.getTemplates().file, getTimeout(f.getFileConfig().TimeoutSeconds), commandState, func(commandOutput []byte, stderr []byte, commandErr error) {
+		defer wg.Done()
+		recordCommandResult(f.settings, f.lastCommand, f.commandState.RelativePath, stderr, commandErr)
+		output = commandOutput
+		readErr = commandErr
+	}))
+	wg.Wait()
+	if readErr != nil {
+		log.Error(fmt.Sprintf("Unable to get contents for '%s': %v", f.commandState.RelativePath, readErr))
 	}
 
-	return f, fuse.FOPEN_DIRECT_IO, 0
+	return output, readErr
 }
 
-func (f *file) Getattr(ctx context.Context, out *fuse.AttrOut) syscall.Errno {
-	log.Debug("Getaddr called for file")
+func (f *file) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	log.Debug("Getattr called for file")
 	f.getattr(out)
 	return 0
 }
 
 func (f *file) getattr(out *fuse.AttrOut) {
-	out.Mode = f.config.Mode
+	out.Mode = f.getFileConfig().Mode
 	out.Mtime = f.attr.Mtime
 	out.Ctime = f.attr.Ctime
 	out.Atime = f.attr.Atime
+	if f.shouldKeepCache() {
+		// The kernel does not read past the size of files it caches the content of
+		out.Size = uint64(len(f.content))
+	}
+	f.getEntryAttr().apply(out)
 }
 
 func (f *file) OnAdd(ctx context.Context) {
@@ -88,7 +216,7 @@ func (f *file) OnAdd(ctx context.Context) {
 
 func (f *file) Release(ctx context.Context) syscall.Errno {
 	log.Debug("Release called for file")
-	if isContentStale(f) {
+	if isContentStale(f) && !f.shouldKeepCache() {
 		log.Debug("File content is stale, clearing cache")
 		f.content = []byte{}
 	}
@@ -96,22 +224,89 @@ func (f *file) Release(ctx context.Context) syscall.Errno {
 	return 0
 }
 
+func (f *file) getLastCommand() *commandResult {
+	return f.lastCommand
+}
+
+func (f *file) Getxattr(ctx context.Context, attr string, dest []byte) (uint32, syscall.Errno) {
+	fileAccess := f.getFileConfig().Access
+	if errno := checkAccess(ctx, f.settings, f.commandState, "Getxattr", &fileAccess); errno != 0 {
+		return 0, errno
+	}
+	return getResultXattr(f.lastCommand, attr, dest)
+}
+
+func (f *file) Listxattr(ctx context.Context, dest []byte) (uint32, syscall.Errno) {
+	fileAccess := f.getFileConfig().Access
+	if errno := checkAccess(ctx, f.settings, f.commandState, "Listxattr", &fileAccess); errno != 0 {
+		return 0, errno
+	}
+	return listResultXattrs(f.lastCommand, dest)
+}
+
 func (f *file) getAttr() *fuse.Attr {
 	return f.attr
 }
 
+func (f *file) getFileConfig() config.File {
+	return f.settings.getFileConfig()
+}
+
+func (f *file) getEntryAttr() entryAttr {
+	f.entryAttrMutex.Lock()
+	defer f.entryAttrMutex.Unlock()
+	return f.entryAttr
+}
+
+func (f *file) setEntryAttr(attr entryAttr) {
+	f.entryAttrMutex.Lock()
+	defer f.entryAttrMutex.Unlock()
+	f.entryAttr = attr
+}
+
 func (f *file) getCacheSeconds() uint64 {
-	return f.config.CacheSeconds
+	if cacheSeconds := f.getEntryAttr().cacheSeconds; cacheSeconds != nil {
+		return *cacheSeconds
+	}
+	return f.getFileConfig().CacheSeconds
 }
 
 func (f *file) shouldCache() bool {
-	return f.config.Cache
+	if cacheSeconds := f.getEntryAttr().cacheSeconds; cacheSeconds != nil {
+		return *cacheSeconds > 0
+	}
+	return f.getFileConfig().Cache
+}
+
+// shouldKeepCache returns true if the kernel should cache the file's content. Content loaded for
+// each caller is never cached by the kernel.
+func (f *file) shouldKeepCache() bool {
+	return f.getFileConfig().KeepCache && f.shouldCache()
+}
+
+func (f *file) getLoadedAt() time.Time {
+	return f.loadedAt
+}
+
+func (f *file) setLoadedAt(loadedAt time.Time) {
+	f.loadedAt = loadedAt
+}
+
+func (f *file) invalidate() {
+	invalidate(f)
+	f.callerContentsMutex.Lock()
+	defer f.callerContentsMutex.Unlock()
+	f.callerContents = map[string]*callerContent{}
 }
 
 var _ = (fs.InodeEmbedder)((*file)(nil))
 var _ = (fs.FileHandle)((*file)(nil))
-var _ = (fs.FileReader)((*file)(nil))    // Contains Read
-var _ = (fs.FileGetattrer)((*file)(nil)) // Contains Getattr
-var _ = (fs.NodeOnAdder)((*file)(nil))   // Contains OnAdd
-var _ = (fs.FileReleaser)((*file)(nil))  // Contains Release
-var _ = (fs.NodeOpener)((*file)(nil))    // Contains Open
+var _ = (fs.FileReader)((*file)(nil))      // Contains Read
+var _ = (fs.NodeGetattrer)((*file)(nil))   // Contains Getattr
+var _ = (fs.NodeOnAdder)((*file)(nil))     // Contains OnAdd
+var _ = (fs.FileReleaser)((*file)(nil))    // Contains Release
+var _ = (fs.NodeOpener)((*file)(nil))      // Contains Open
+var _ = (fs.NodeGetxattrer)((*file)(nil))  // Contains Getxattr
+var _ = (fs.NodeListxattrer)((*file)(nil)) // Contains Listxattr
+
+var _ = (fs.FileReader)((*contentHandle)(nil)) // Contains Read
@@ -0,0 +1,246 @@
+package mount
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/jasonrogena/fusee/internal/app/fusee/config"
+	"github.com/jasonrogena/fusee/internal/pkg/command"
+)
+
+// writeTestScript writes a shell script to a temporary directory and returns its path.
+func writeTestScript(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "script.sh")
+	if writeErr := os.WriteFile(path, []byte(script), 0o755); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	return path
+}
+
+// newTestFile returns a file of a mount with conf, added as if it was in a mounted tree.
+func newTestFile(t *testing.T, conf config.Mount) *file {
+	t.Helper()
+	mountSettings, settingsErr := newSettings("test", conf, nil)
+	if settingsErr != nil {
+		t.Fatalf("Unable to create the mount's settings: %v", settingsErr)
+	}
+	pool := command.NewPool(2)
+	pool.Start()
+	t.Cleanup(func() { pool.Stop(0) })
+	f := NewFile(mountSettings, command.NewState("test", conf.Path, "file", "file"), pool)
+	f.OnAdd(context.Background())
+	return f
+}
+
+// newCallerContext returns a context of a FUSE request made by a caller with uid.
+func newCallerContext(uid uint32) context.Context {
+	return fuse.NewContext(context.Background(), &fuse.Caller{
+		Owner: fuse.Owner{Uid: uid, Gid: uid},
+		Pid:   uint32(os.Getpid()),
+	})
+}
+
+// readTestFile opens f for ctx and returns all the content read from the opened handle.
+func readTestFile(t *testing.T, f *file, ctx context.Context) string {
+	t.Helper()
+	fh, _, errno := f.Open(ctx, 0)
+	if errno != 0 {
+		t.Fatalf("Unable to open the file: %v", errno)
+	}
+	result, errno := fh.(fs.FileReader).Read(ctx, make([]byte, 1024), 0)
+	if errno != 0 {
+		t.Fatalf("Unable to read the file: %v", errno)
+	}
+	content, _ := result.Bytes(make([]byte, 1024))
+	return strings.TrimSpace(string(content))
+}
+
+func TestOpenForCallers(t *testing.T) {
+	script := writeTestScript(t, "echo \"uid:$FUSEE_CALLER_UID\"\n")
+	tests := []struct {
+		name      string
+		perCaller bool
+		expected  []string
+	}{
+		{"per caller", true, []string{"uid:1000", "uid:1001", "uid:1000"}},
+		{"shared", false, []string{"uid:", "uid:", "uid:"}},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			f := newTestFile(t, config.Mount{
+				Path: "/mnt",
+				File: config.File{
+					ReadCommand:  "sh " + script,
+					Cache:        true,
+					CacheSeconds: 60,
+					PerCaller:    curTest.perCaller,
+				},
+			})
+			for curIndex, curUID := range []uint32{1000, 1001, 1000} {
+				if content := readTestFile(t, f, newCallerContext(curUID)); content != curTest.expected[curIndex] {
+					t.Errorf("Expected '%s' for %d, got '%s'", curTest.expected[curIndex], curUID, content)
+				}
+			}
+		})
+	}
+}
+
+func TestKeepCacheOpenFlags(t *testing.T) {
+	tests := []struct {
+		name          string
+		keepCache     bool
+		expectedFlags []uint32
+		expectedSizes []uint64
+	}{
+		// The content changes on the first open, and on the third since the file is invalidated
+		{"keep cache", true, []uint32{0, fuse.FOPEN_KEEP_CACHE, 0}, []uint64{6, 6, 6}},
+		{"direct", false, []uint32{fuse.FOPEN_DIRECT_IO, fuse.FOPEN_DIRECT_IO, fuse.FOPEN_DIRECT_IO}, []uint64{0, 0, 0}},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			counter := filepath.Join(t.TempDir(), "counter")
+			script := writeTestScript(t, "echo x >> "+counter+"\nprintf 'run %d\\n' $(wc -l < "+counter+")\n")
+			f := newTestFile(t, config.Mount{
+				Path: "/mnt",
+				File: config.File{
+					ReadCommand:  "sh " + script,
+					Mode:         0o444,
+					Cache:        true,
+					CacheSeconds: 60,
+					KeepCache:    curTest.keepCache,
+				},
+			})
+			for curIndex, curFlags := range curTest.expectedFlags {
+				if curIndex == 2 {
+					f.invalidate()
+				}
+				_, flags, errno := f.Open(context.Background(), 0)
+				if errno != 0 {
+					t.Fatalf("Unable to open the file: %v", errno)
+				}
+				if flags != curFlags {
+					t.Errorf("Expected open %d to return the flags %d, got %d", curIndex, curFlags, flags)
+				}
+				out := fuse.AttrOut{}
+				if errno := f.Getattr(context.Background(), nil, &out); errno != 0 || out.Size != curTest.expectedSizes[curIndex] {
+					t.Errorf("Expected the size %d after open %d, got %d, %v", curTest.expectedSizes[curIndex], curIndex, out.Size, errno)
+				}
+			}
+		})
+	}
+}
+
+// TestKeepCacheLookup checks that the kernel is given the size of files with keepCache set when
+// it looks them up, since it does not read past the size of files it caches the content of.
+func TestKeepCacheLookup(t *testing.T) {
+	r := newTestRoot(t, config.Mount{
+		Path:          t.TempDir(),
+		ReadCommand:   "echo secret",
+		NameSeparator: "\n",
+		Mode:          0o555,
+		ThreadCount:   2,
+		Cache:         true,
+		CacheSeconds:  60,
+		File:          config.File{Mode: 0o444, ReadCommand: "echo contents of secret", Cache: true, CacheSeconds: 60, KeepCache: true},
+	})
+	child, errno := r.Lookup(context.Background(), "secret", &fuse.EntryOut{})
+	if errno != 0 {
+		t.Fatalf("Unable to look up the file: %v", errno)
+	}
+	if _, _, errno := child.Operations().(fs.NodeOpener).Open(context.Background(), 0); errno != 0 {
+		t.Fatalf("Unable to open the file: %v", errno)
+	}
+	out := fuse.EntryOut{}
+	if _, errno := r.Lookup(context.Background(), "secret", &out); errno != 0 || out.Size != uint64(len("contents of secret\n")) {
+		t.Errorf("Expected the size %d, got %d, %v", len("contents of secret\n"), out.Size, errno)
+	}
+}
+
+// TestKeepCacheRelist checks that the kernel is allowed to keep the cached content of a file with
+// keepCache set when the file's directory is listed again, as long as the content has not changed.
+func TestKeepCacheRelist(t *testing.T) {
+	r := newTestRoot(t, config.Mount{
+		Path:          t.TempDir(),
+		ReadCommand:   "echo secret",
+		NameSeparator: "\n",
+		Mode:          0o555,
+		ThreadCount:   2,
+		Cache:         true,
+		CacheSeconds:  60,
+		File:          config.File{Mode: 0o444, ReadCommand: "echo contents of secret", Cache: true, CacheSeconds: 60, KeepCache: true},
+	})
+	expectedFlags := []uint32{0, fuse.FOPEN_KEEP_CACHE}
+	for curIndex, curFlags := range expectedFlags {
+		if curIndex > 0 {
+			r.invalidate()
+			if loadErr := loadChildren(context.Background(), r); loadErr != nil {
+				t.Fatal(loadErr)
+			}
+		}
+		child, errno := r.Lookup(context.Background(), "secret", &fuse.EntryOut{})
+		if errno != 0 {
+			t.Fatalf("Unable to look up the file: %v", errno)
+		}
+		if _, flags, errno := child.Operations().(fs.NodeOpener).Open(context.Background(), 0); errno != 0 || flags != curFlags {
+			t.Errorf("Expected open %d to return the flags %d, got %d, %v", curIndex, curFlags, flags, errno)
+		}
+		out := fuse.AttrOut{}
+		if child.Operations().(fs.NodeGetattrer).Getattr(context.Background(), nil, &out); out.Size != uint64(len("contents of secret\n")) {
+			t.Errorf("Expected the size %d after open %d, got %d", len("contents of secret\n"), curIndex, out.Size)
+		}
+	}
+}
+
+// TestKeepCacheMounted reads a file with keepCache set twice through a mount. The test is skipped
+// if FUSE cannot be mounted.
+func TestKeepCacheMounted(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "counter")
+	r, rootErr := NewRoot("test", config.Mount{
+		Path:          t.TempDir(),
+		ReadCommand:   "echo secret",
+		NameSeparator: "\n",
+		Mode:          0o555,
+		ThreadCount:   2,
+		Cache:         true,
+		CacheSeconds:  60,
+		File:          config.File{Mode: 0o444, ReadCommand: "echo x >> " + counter + "; echo contents of secret", Cache: true, CacheSeconds: 60, KeepCache: true},
+	})
+	if rootErr != nil {
+		t.Fatal(rootErr)
+	}
+	server, mountErr := r.Mount(false)
+	if mountErr != nil {
+		t.Skipf("Unable to mount: %v", mountErr)
+	}
+	defer func() {
+		if unmountErr := r.Unmount(time.Now().Add(5 * time.Second)); unmountErr != nil {
+			t.Error(unmountErr)
+		}
+		server.Wait()
+		r.StopCommands(0)
+	}()
+
+	path := filepath.Join(r.getMountConfig().Path, "secret")
+	for i := 0; i < 2; i++ {
+		content, readErr := os.ReadFile(path)
+		if readErr != nil {
+			t.Fatalf("Unable to read the file: %v", readErr)
+		}
+		if string(content) != "contents of secret\n" {
+			t.Errorf("Expected read %d to return the file's content, got '%s'", i, content)
+		}
+	}
+	if info, statErr := os.Stat(path); statErr != nil || info.Size() != int64(len("contents of secret\n")) {
+		t.Errorf("Expected the file's size to be %d, got %v, %v", len("contents of secret\n"), info, statErr)
+	}
+	if runs, readErr := os.ReadFile(counter); readErr != nil || strings.Count(string(runs), "x") != 1 {
+		t.Errorf("Expected the read command to run once, got '%s', %v", runs, readErr)
+	}
+}
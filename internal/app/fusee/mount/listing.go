@@ -0,0 +1,198 @@
+package mount
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// The formats directory read commands can list entries in
+const (
+	// Names separated by the name separator
+	listFormatText = "text"
+	// A JSON array of entry objects
+	listFormatJSON = "json"
+	// One JSON entry object per line
+	listFormatJSONL = "jsonl"
+)
+
+// getListFormat returns the list format with the provided name. Defaults to text.
+func getListFormat(name string) string {
+	if len(name) == 0 {
+		return listFormatText
+	}
+
+	return name
+}
+
+// The types of the entries in JSON listings
+const (
+	entryTypeFile    = "file"
+	entryTypeDir     = "dir"
+	entryTypeSymlink = "symlink"
+)
+
+// parseEntryType parses the type printed by a directory's typeCommand or lookupCommand. Only
+// directories and files can be typed this way since symlinks also need a target, which only JSON
+// listings can provide.
+func parseEntryType(output []byte) (string, error) {
+	entryType := strings.TrimSpace(string(output))
+	if entryType == entryTypeSymlink {
+		return "", fmt.Errorf("Entry type '%s' is only supported in JSON listings", entryType)
+	}
+	if entryType != entryTypeDir && entryType != entryTypeFile {
+		return "", fmt.Errorf("Unknown entry type '%s', should be '%s' or '%s'", entryType, entryTypeDir, entryTypeFile)
+	}
+
+	return entryType, nil
+}
+
+// listEntry is an entry listed by a directory read command. Only the name is set for entries
+// listed as text, in which case the type of the entry is found by probing it.
+type listEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Overrides the mode of the node set in the configuration
+	Mode entryMode `json:"mode"`
+	Size uint64    `json:"size"`
+	// The modification time of the node, in seconds since the Unix epoch
+	Mtime uint64 `json:"mtime"`
+	// The path a symlink points to
+	Target string `json:"target"`
+	// Overrides the number of seconds the content of the node is cached for
+	CacheSeconds *uint64 `json:"cacheSeconds"`
+}
+
+// entryMode is a mode in a JSON listing. It can either be a number or a string with the mode in
+// octal, for instance "0644".
+type entryMode uint32
+
+func (m *entryMode) UnmarshalJSON(data []byte) error {
+	var modeText string
+	if unmarshalErr := json.Unmarshal(data, &modeText); unmarshalErr != nil {
+		var mode uint32
+		if numberErr := json.Unmarshal(data, &mode); numberErr != nil {
+			return fmt.Errorf("Mode should be a number or an octal string: %w", numberErr)
+		}
+		*m = entryMode(mode)
+		return nil
+	}
+	mode, parseErr := strconv.ParseUint(modeText, 8, 32)
+	if parseErr != nil {
+		return fmt.Errorf("Mode '%s' is not an octal number: %w", modeText, parseErr)
+	}
+	*m = entryMode(mode)
+
+	return nil
+}
+
+// ParseNames returns the names listed, separated by separator, in the output of a directory read
+// command listing entries as text. Blank names are skipped.
+func ParseNames(output []byte, separator string) []string {
+	names := []string{}
+	for _, curName := range strings.Split(string(output), separator) {
+		if curName = strings.TrimSpace(curName); len(curName) > 0 {
+			names = append(names, curName)
+		}
+	}
+
+	return names
+}
+
+// parseListing parses the output of a directory read command into the entries it lists.
+func parseListing(format string, separator string, output []byte) ([]listEntry, error) {
+	entries := []listEntry{}
+	switch format {
+	case listFormatJSON:
+		if len(bytes.TrimSpace(output)) == 0 {
+			return entries, nil
+		}
+		if unmarshalErr := json.Unmarshal(output, &entries); unmarshalErr != nil {
+			return nil, fmt.Errorf("Unable to parse JSON listing: %w", unmarshalErr)
+		}
+	case listFormatJSONL:
+		scanner := bufio.NewScanner(bytes.NewReader(output))
+		scanner.Buffer([]byte{}, len(output)+1)
+		lineNo := 0
+		for scanner.Scan() {
+			lineNo++
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			entry := listEntry{}
+			if unmarshalErr := json.Unmarshal(scanner.Bytes(), &entry); unmarshalErr != nil {
+				return nil, fmt.Errorf("Unable to parse line %d of JSON Lines listing: %w", lineNo, unmarshalErr)
+			}
+			entries = append(entries, entry)
+		}
+	default:
+		for _, curName := range ParseNames(output, separator) {
+			entries = append(entries, listEntry{Name: curName})
+		}
+		return entries, nil
+	}
+
+	for _, curEntry := range entries {
+		if entryErr := validateEntry(curEntry); entryErr != nil {
+			return nil, entryErr
+		}
+	}
+
+	return entries, nil
+}
+
+func validateEntry(entry listEntry) error {
+	if len(entry.Name) == 0 {
+		return fmt.Errorf("Entry of type '%s' does not have a name", entry.Type)
+	}
+	if strings.Contains(entry.Name, "/") {
+		return fmt.Errorf("Entry name '%s' cannot contain '/'", entry.Name)
+	}
+	switch entry.Type {
+	case entryTypeFile, entryTypeDir:
+		return nil
+	case entryTypeSymlink:
+		if len(entry.Target) == 0 {
+			return fmt.Errorf("Symlink '%s' does not have a target", entry.Name)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Entry '%s' has unknown type '%s'", entry.Name, entry.Type)
+}
+
+// entryAttr holds the attributes of a node set by the JSON listing it was in. Attributes with a
+// zero value are not set.
+type entryAttr struct {
+	mode         uint32
+	size         uint64
+	mtime        uint64
+	cacheSeconds *uint64
+}
+
+func newEntryAttr(entry listEntry) entryAttr {
+	return entryAttr{
+		mode:         uint32(entry.Mode),
+		size:         entry.Size,
+		mtime:        entry.Mtime,
+		cacheSeconds: entry.CacheSeconds,
+	}
+}
+
+// apply overrides the attributes in out with the ones that are set.
+func (a entryAttr) apply(out *fuse.AttrOut) {
+	if a.mode != 0 {
+		out.Mode = a.mode
+	}
+	if a.size != 0 {
+		out.Size = a.size
+	}
+	if a.mtime != 0 {
+		out.Mtime = a.mtime
+	}
+}
@@ -0,0 +1,187 @@
+package mount
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/jasonrogena/fusee/internal/app/fusee/config"
+)
+
+// newTestRoot returns the root of a mount with conf, with its children loaded as they are when the
+// mount is mounted.
+func newTestRoot(t *testing.T, conf config.Mount) *root {
+	t.Helper()
+	r, rootErr := NewRoot("test", conf)
+	if rootErr != nil {
+		t.Fatal(rootErr)
+	}
+	fs.NewNodeFS(r, &fs.Options{})
+	t.Cleanup(func() { r.StopCommands(0) })
+	return r
+}
+
+func TestParseListing(t *testing.T) {
+	cacheSeconds := uint64(0)
+	tests := []struct {
+		name      string
+		format    string
+		output    string
+		expected  []listEntry
+		expectErr bool
+	}{
+		{"text", listFormatText, "a\n b \n", []listEntry{{Name: "a"}, {Name: "b"}}, false},
+		{"empty json", listFormatJSON, " \n", []listEntry{}, false},
+		{"json", listFormatJSON, `[{"name": "a", "type": "file", "size": 3, "mtime": 1600000000}, {"name": "b", "type": "dir", "mode": "0750"}]`,
+			[]listEntry{{Name: "a", Type: "file", Size: 3, Mtime: 1600000000}, {Name: "b", Type: "dir", Mode: 0o750}}, false},
+		{"numeric mode", listFormatJSON, `[{"name": "a", "type": "file", "mode": 420}]`, []listEntry{{Name: "a", Type: "file", Mode: 0o644}}, false},
+		{"symlink", listFormatJSON, `[{"name": "a", "type": "symlink", "target": "../b"}]`, []listEntry{{Name: "a", Type: "symlink", Target: "../b"}}, false},
+		{"cache seconds", listFormatJSON, `[{"name": "a", "type": "file", "cacheSeconds": 0}]`, []listEntry{{Name: "a", Type: "file", CacheSeconds: &cacheSeconds}}, false},
+		{"jsonl", listFormatJSONL, "{\"name\": \"a\", \"type\": \"file\"}\n\n{\"name\": \"b\", \"type\": \"dir\"}\n",
+			[]listEntry{{Name: "a", Type: "file"}, {Name: "b", Type: "dir"}}, false},
+		{"empty jsonl", listFormatJSONL, "", []listEntry{}, false},
+		{"invalid json", listFormatJSON, `[{"name": "a"`, nil, true},
+		{"invalid jsonl line", listFormatJSONL, "{\"name\": \"a\", \"type\": \"file\"}\nnot json\n", nil, true},
+		{"invalid octal mode", listFormatJSON, `[{"name": "a", "type": "file", "mode": "0999"}]`, nil, true},
+		{"invalid mode", listFormatJSON, `[{"name": "a", "type": "file", "mode": true}]`, nil, true},
+		{"no name", listFormatJSON, `[{"type": "file"}]`, nil, true},
+		{"name with slash", listFormatJSON, `[{"name": "a/b", "type": "file"}]`, nil, true},
+		{"no type", listFormatJSONL, `{"name": "a"}`, nil, true},
+		{"unknown type", listFormatJSON, `[{"name": "a", "type": "socket"}]`, nil, true},
+		{"symlink without target", listFormatJSON, `[{"name": "a", "type": "symlink"}]`, nil, true},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			entries, parseErr := parseListing(curTest.format, "\n", []byte(curTest.output))
+			if (parseErr != nil) != curTest.expectErr {
+				t.Fatalf("Expected an error: %t, got '%v'", curTest.expectErr, parseErr)
+			}
+			if !curTest.expectErr && !reflect.DeepEqual(entries, curTest.expected) {
+				t.Errorf("Expected %+v, got %+v", curTest.expected, entries)
+			}
+		})
+	}
+}
+
+// TestJSONListingAttributes checks that the attributes set in JSON listings are the ones the
+// kernel is given when it looks up, and gets the attributes of, the entries.
+func TestJSONListingAttributes(t *testing.T) {
+	type expectedAttr struct {
+		name  string
+		mode  uint32
+		size  uint64
+		mtime uint64
+	}
+	tests := []struct {
+		format   string
+		listing  string
+		expected []expectedAttr
+	}{
+		{
+			listFormatJSON,
+			`[{"name": "secret", "type": "file", "mode": "0640", "size": 42, "mtime": 1600000000}, {"name": "plain", "type": "file"}, {"name": "sub", "type": "dir", "mode": 448, "mtime": 1500000000}, {"name": "link", "type": "symlink", "target": "secret"}]`,
+			[]expectedAttr{
+				{"secret", 0o640, 42, 1600000000},
+				{"plain", 0o444, 0, 0},
+				{"sub", 0o700, 0, 1500000000},
+				{"link", 0o777, 6, 0},
+			},
+		},
+		{
+			listFormatJSONL,
+			"{\"name\": \"secret\", \"type\": \"file\", \"mode\": \"0600\", \"size\": 7, \"mtime\": 1700000000}\n{\"name\": \"sub\", \"type\": \"dir\"}\n",
+			[]expectedAttr{
+				{"secret", 0o600, 7, 1700000000},
+				{"sub", 0o555, 0, 0},
+			},
+		},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.format, func(t *testing.T) {
+			listing := writeTestScript(t, "cat <<'EOF'\n"+curTest.listing+"\nEOF\n")
+			r := newTestRoot(t, config.Mount{
+				Path:        t.TempDir(),
+				ReadCommand: "sh " + listing,
+				ListFormat:  curTest.format,
+				Mode:        0o555,
+				ThreadCount: 2,
+				Cache:       true,
+				Directory:   config.Directory{Mode: 0o555, ReadCommand: "true", ListFormat: curTest.format, Cache: true},
+				File:        config.File{Mode: 0o444, ReadCommand: "true"},
+			})
+			for _, curExpected := range curTest.expected {
+				entryOut := fuse.EntryOut{}
+				child, errno := r.Lookup(context.Background(), curExpected.name, &entryOut)
+				if errno != 0 {
+					t.Fatalf("Unable to look up '%s': %v", curExpected.name, errno)
+				}
+				attrOut := fuse.AttrOut{}
+				if errno := child.Operations().(fs.NodeGetattrer).Getattr(context.Background(), nil, &attrOut); errno != 0 {
+					t.Fatalf("Unable to get the attributes of '%s': %v", curExpected.name, errno)
+				}
+				for _, curAttr := range []fuse.Attr{entryOut.Attr, attrOut.Attr} {
+					if curAttr.Mode != curExpected.mode || curAttr.Size != curExpected.size {
+						t.Errorf("Expected '%s' to have the mode %o and size %d, got %o and %d", curExpected.name, curExpected.mode, curExpected.size, curAttr.Mode, curAttr.Size)
+					}
+					if curExpected.mtime != 0 && curAttr.Mtime != curExpected.mtime {
+						t.Errorf("Expected '%s' to have the mtime %d, got %d", curExpected.name, curExpected.mtime, curAttr.Mtime)
+					}
+				}
+			}
+			if _, errno := r.Lookup(context.Background(), "missing", &fuse.EntryOut{}); errno != syscall.ENOENT {
+				t.Errorf("Expected ENOENT for an entry that is not listed, got %v", errno)
+			}
+		})
+	}
+}
+
+// TestRelistKeepsChildren checks that listing a directory again keeps the nodes of the entries
+// whose type did not change, updating their attributes, and replaces the nodes of the others.
+func TestRelistKeepsChildren(t *testing.T) {
+	listingPath := filepath.Join(t.TempDir(), "listing.json")
+	writeListing := func(listing string) {
+		if writeErr := os.WriteFile(listingPath, []byte(listing), 0o644); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+	}
+	writeListing(`[{"name": "file", "type": "file", "size": 1}, {"name": "dir", "type": "dir"}, {"name": "link", "type": "symlink", "target": "file"}, {"name": "changing", "type": "file"}]`)
+	r := newTestRoot(t, config.Mount{
+		Path:         t.TempDir(),
+		ReadCommand:  "cat " + listingPath,
+		ListFormat:   listFormatJSON,
+		Mode:         0o555,
+		ThreadCount:  2,
+		Cache:        true,
+		CacheSeconds: 60,
+		Directory:    config.Directory{Mode: 0o555, ReadCommand: "true", ListFormat: listFormatJSON},
+		File:         config.File{Mode: 0o444, ReadCommand: "true"},
+	})
+	before := r.Children()
+
+	writeListing(`[{"name": "file", "type": "file", "size": 2}, {"name": "dir", "type": "dir"}, {"name": "link", "type": "symlink", "target": "dir"}, {"name": "changing", "type": "dir"}]`)
+	r.invalidate()
+	if loadErr := loadChildren(context.Background(), r); loadErr != nil {
+		t.Fatal(loadErr)
+	}
+	after := r.Children()
+	for _, curName := range []string{"file", "dir", "link"} {
+		if after[curName] != before[curName] {
+			t.Errorf("Expected '%s' to keep its node", curName)
+		}
+	}
+	if after["changing"] == before["changing"] || getNodeType(after["changing"]) != entryTypeDir {
+		t.Errorf("Expected 'changing' to be replaced by a directory, got a %s", getNodeType(after["changing"]))
+	}
+	attrOut := fuse.AttrOut{}
+	if after["file"].Operations().(fs.NodeGetattrer).Getattr(context.Background(), nil, &attrOut); attrOut.Size != 2 {
+		t.Errorf("Expected the size of 'file' to be updated to 2, got %d", attrOut.Size)
+	}
+	if target, _ := after["link"].Operations().(fs.NodeReadlinker).Readlink(context.Background()); string(target) != "dir" {
+		t.Errorf("Expected the target of 'link' to be updated to 'dir', got '%s'", target)
+	}
+}
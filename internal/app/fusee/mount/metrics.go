@@ -0,0 +1,39 @@
+package mount
+
+import (
+	"time"
+
+	"github.com/jasonrogena/fusee/internal/pkg/metrics"
+)
+
+var operationsTotal = metrics.NewCounterVec(
+	"fusee_fuse_operations_total",
+	"Number of FUSE operations handled.",
+	"mount", "operation")
+var operationDuration = metrics.NewHistogramVec(
+	"fusee_fuse_operation_duration_seconds",
+	"Time taken to handle FUSE operations.",
+	metrics.DefaultBuckets,
+	"mount", "operation")
+var cacheRequestsTotal = metrics.NewCounterVec(
+	"fusee_cache_requests_total",
+	"Number of times the cached content of a node was needed, by whether the content was fresh (hit) or had to be loaded (miss).",
+	"mount", "node_type", "result")
+var bytesServedTotal = metrics.NewCounterVec(
+	"fusee_bytes_served_total",
+	"Number of bytes read from files.",
+	"mount")
+
+// observeOperation records a FUSE operation that started at startTime. Meant to be deferred.
+func observeOperation(mountName string, operation string, startTime time.Time) {
+	operationsTotal.Inc(mountName, operation)
+	operationDuration.Observe(time.Now().Sub(startTime).Seconds(), mountName, operation)
+}
+
+func observeCacheRequest(mountName string, nodeType string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheRequestsTotal.Inc(mountName, nodeType, result)
+}
@@ -0,0 +1,44 @@
+package mount
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/jasonrogena/fusee/internal/app/fusee/config"
+)
+
+const defaultFsName = "fusee"
+const defaultSubtype = "fusee"
+
+// getMountOptions builds the options to mount the mount with from its configuration.
+func getMountOptions(conf config.Mount) *fs.Options {
+	opts := &fs.Options{}
+	opts.AllowOther = conf.AllowOther
+	opts.DirectMount = conf.DirectMount
+	opts.MaxReadAhead = conf.MaxReadAhead
+	opts.FsName = conf.FsName
+	if len(opts.FsName) == 0 {
+		opts.FsName = defaultFsName
+	}
+	opts.Name = conf.Subtype
+	if len(opts.Name) == 0 {
+		opts.Name = defaultSubtype
+	}
+	if conf.DefaultPermissions {
+		opts.Options = append(opts.Options, "default_permissions")
+	}
+	if conf.MaxRead > 0 {
+		opts.Options = append(opts.Options, fmt.Sprintf("max_read=%d", conf.MaxRead))
+	}
+	opts.EntryTimeout = secondsToDuration(conf.EntryTimeoutSeconds)
+	opts.AttrTimeout = secondsToDuration(conf.AttrTimeoutSeconds)
+	opts.NegativeTimeout = secondsToDuration(conf.NegativeTimeoutSeconds)
+
+	return opts
+}
+
+func secondsToDuration(seconds float64) *time.Duration {
+	duration := time.Duration(seconds * float64(time.Second))
+	return &duration
+}
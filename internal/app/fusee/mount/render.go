@@ -0,0 +1,129 @@
+package mount
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+const renderPreviewLength = 40
+
+// renderable is a node whose attributes can be read without a FUSE request, for instance when
+// rendering the mount or setting the attributes of an entry returned by a lookup.
+type renderable interface {
+	getattr(out *fuse.AttrOut)
+}
+
+// Render builds the mount's tree, without mounting it, by running the same commands that would
+// be run by the FUSE operations against the mount. The tree is then written to w. Directories
+// deeper than maxDepth are not expanded. If maxDepth is negative, all directories are expanded.
+func (r *root) Render(w io.Writer, maxDepth int) {
+	// Building the node FS adds the root, and consequently loads its children, without mounting it
+	fs.NewNodeFS(r, &fs.Options{})
+	defer r.StopCommands(0)
+
+	ctx := context.Background()
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "TYPE\tMODE\tSIZE\tPATH\tPREVIEW\n")
+	renderNode(tw, r, ".", 0)
+	renderChildren(ctx, tw, r, "", 1, maxDepth)
+	tw.Flush()
+}
+
+func renderChildren(ctx context.Context, tw *tabwriter.Writer, p parent, pathPrefix string, depth int, maxDepth int) {
+	if maxDepth >= 0 && depth > maxDepth {
+		return
+	}
+	if readdirer, ok := p.(fs.NodeReaddirer); ok {
+		stream, errno := readdirer.Readdir(ctx)
+		if stream != nil {
+			stream.Close()
+		}
+		if errno != 0 {
+			return
+		}
+	}
+
+	children := p.getChildren()
+	names := []string{}
+	for curName := range children {
+		names = append(names, curName)
+	}
+	sort.Strings(names)
+	for _, curName := range names {
+		curPath := pathPrefix + curName
+		switch curNode := children[curName].Operations().(type) {
+		case *directory:
+			renderNode(tw, curNode, curPath+string(os.PathSeparator), 0)
+			renderChildren(ctx, tw, curNode, curPath+string(os.PathSeparator), depth+1, maxDepth)
+		case *file:
+			fh, _, errno := curNode.Open(ctx, 0)
+			if errno != 0 {
+				renderNode(tw, curNode, curPath, 0)
+				fmt.Fprintf(tw, "<%v>\n", errno)
+				continue
+			}
+			content := getHandleContent(fh)
+			renderNode(tw, curNode, curPath, len(content))
+			renderFilePreview(tw, content)
+			if releaser, ok := fh.(fs.FileReleaser); ok {
+				releaser.Release(ctx)
+			}
+		case *symlink:
+			renderNode(tw, curNode, curPath, 0)
+			fmt.Fprintf(tw, "-> %s\n", curNode.target)
+		}
+	}
+}
+
+// getHandleContent returns the content of a file opened using the handle.
+func getHandleContent(fh fs.FileHandle) []byte {
+	switch handle := fh.(type) {
+	case *file:
+		return handle.content
+	case *contentHandle:
+		return handle.content
+	}
+
+	return []byte{}
+}
+
+func renderNode(tw *tabwriter.Writer, node renderable, path string, size int) {
+	out := &fuse.AttrOut{}
+	node.getattr(out)
+	mode := os.FileMode(out.Mode).Perm()
+	nodeType := "file"
+	sizeStr := strconv.Itoa(size)
+	switch node.(type) {
+	case *file:
+	case *symlink:
+		nodeType = "link"
+		mode = mode | os.ModeSymlink
+		sizeStr = "-"
+	default:
+		nodeType = "dir"
+		mode = mode | os.ModeDir
+		sizeStr = "-"
+	}
+	fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t", nodeType, mode.String(), sizeStr, path)
+	if nodeType == "dir" {
+		fmt.Fprintf(tw, "\n")
+	}
+}
+
+func renderFilePreview(tw *tabwriter.Writer, content []byte) {
+	preview := content
+	suffix := ""
+	if len(preview) > renderPreviewLength {
+		preview = preview[:renderPreviewLength]
+		suffix = "..."
+	}
+	fmt.Fprintf(tw, "%s%s\n", strconv.Quote(string(preview)), suffix)
+}
@@ -0,0 +1,117 @@
+package mount
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/jasonrogena/fusee/internal/pkg/command"
+	log "github.com/sirupsen/logrus"
+)
+
+// The maximum number of bytes of a command's stderr kept for a node. Only the end of the stderr is
+// kept if it's longer.
+const maxStderrLength = 4096
+
+// The extended attributes exposing the result of the last command run for a node
+const (
+	exitStatusXattr = "user.fusee.exit_status"
+	stderrXattr     = "user.fusee.stderr"
+)
+
+// commandResult holds the result of the last command run to load a node's content.
+type commandResult struct {
+	exitStatus string
+	stderr     []byte
+	mutex      *sync.RWMutex
+}
+
+type commandResultHolder interface {
+	getLastCommand() *commandResult
+}
+
+func newCommandResult() *commandResult {
+	return &commandResult{
+		stderr: []byte{},
+		mutex:  new(sync.RWMutex),
+	}
+}
+
+func (c *commandResult) set(stderr []byte, runErr error) {
+	if len(stderr) > maxStderrLength {
+		stderr = stderr[len(stderr)-maxStderrLength:]
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.exitStatus = command.GetExitStatus(runErr)
+	c.stderr = append([]byte{}, stderr...)
+}
+
+func (c *commandResult) get() (string, []byte) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.exitStatus, c.stderr
+}
+
+// recordCommandResult logs the stderr of a command run for the node at relativePath, at the
+// mount's configured stderr log level, and keeps it as the node's last command result.
+func recordCommandResult(s *settings, result *commandResult, relativePath string, stderr []byte, runErr error) {
+	logCommandStderr(s, relativePath, stderr)
+	result.set(stderr, runErr)
+}
+
+// logCommandStderr logs the stderr of a command run for the node at relativePath, at the mount's
+// configured stderr log level.
+func logCommandStderr(s *settings, relativePath string, stderr []byte) {
+	trimmedStderr := strings.TrimSpace(string(stderr))
+	if len(trimmedStderr) > 0 {
+		log.StandardLogger().Log(s.getStderrLogLevel(), fmt.Sprintf("Command for '%s' wrote to stderr: %s", relativePath, trimmedStderr))
+	}
+}
+
+// getResultXattr copies the value of the extended attribute attr, exposing the last command
+// result, into dest.
+func getResultXattr(result *commandResult, attr string, dest []byte) (uint32, syscall.Errno) {
+	exitStatus, stderr := result.get()
+	var value []byte
+	switch attr {
+	case exitStatusXattr:
+		value = []byte(exitStatus)
+	case stderrXattr:
+		value = stderr
+	default:
+		return 0, syscall.ENODATA
+	}
+	if len(exitStatus) == 0 {
+		// No command has been run for the node yet
+		return 0, syscall.ENODATA
+	}
+
+	return copyXattr(dest, value)
+}
+
+// listResultXattrs copies the null terminated names of the extended attributes exposing the last
+// command result into dest.
+func listResultXattrs(result *commandResult, dest []byte) (uint32, syscall.Errno) {
+	exitStatus, _ := result.get()
+	if len(exitStatus) == 0 {
+		return 0, 0
+	}
+	var names bytes.Buffer
+	for _, curName := range []string{exitStatusXattr, stderrXattr} {
+		names.WriteString(curName)
+		names.WriteByte(0)
+	}
+
+	return copyXattr(dest, names.Bytes())
+}
+
+func copyXattr(dest []byte, value []byte) (uint32, syscall.Errno) {
+	if len(dest) < len(value) {
+		return uint32(len(value)), syscall.ERANGE
+	}
+
+	return uint32(copy(dest, value)), 0
+}
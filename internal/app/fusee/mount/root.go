@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"runtime"
-	"sync"
 	"syscall"
 	"time"
 
@@ -18,7 +17,7 @@ import (
 
 type root struct {
 	fs.Inode
-	config              config.Mount
+	settings            *settings
 	name                string
 	readDirCounter      int
 	attr                *fuse.Attr
@@ -26,40 +25,97 @@ type root struct {
 	dirEntryPointer     int
 	commandRunnerPool   *command.Pool
 	cachedTestRunOutput []byte
+	server              *fuse.Server
+	lastCommand         *commandResult
+	// When the content was last loaded. Zero if it has not been loaded since the node was added or
+	// invalidated
+	loadedAt time.Time
 }
 
-func NewRoot(name string, conf config.Mount) *root {
+// NewRoot creates the root of a mount. Returns an error if any of the command templates in the
+// mount's configuration cannot be parsed.
+func NewRoot(name string, conf config.Mount) (*root, error) {
+	return NewRootWithBackend(name, conf, nil)
+}
+
+// NewRootWithBackend creates the root of a mount whose commands are all sent to backend, instead
+// of the commands in the mount's configuration being run. If backend is nil, the root is the same
+// as the one returned by NewRoot.
+func NewRootWithBackend(name string, conf config.Mount, backend command.Backend) (*root, error) {
+	mountSettings, settingsErr := newSettings(name, conf, backend)
+	if settingsErr != nil {
+		return nil, fmt.Errorf("Invalid configuration for mount '%s': %w", name, settingsErr)
+	}
+
 	return &root{
-		config:              conf,
+		settings:            mountSettings,
 		name:                name,
 		cachedTestRunOutput: []byte{},
-	}
+		lastCommand:         newCommandResult(),
+	}, nil
 }
 
-func (r *root) Mount(debug bool) error {
-	opts := &fs.Options{}
-	// opts.Debug = debug
+// Mount mounts the root on the configured path and returns the server handling the mount.
+// The call does not block. Call Wait on the returned server to block until the mount ends.
+func (r *root) Mount(debug bool) (*fuse.Server, error) {
+	opts := getMountOptions(r.getMountConfig())
+	opts.Debug = debug
 
 	log.Debug(fmt.Sprintf("Beginning the mounting process for '%s'", r.name))
-	server, serverErr := fs.Mount(r.config.Path, r, opts)
-	log.Debug(fmt.Sprintf("fs.Mount called for '%s'. About to start waiting for mount", r.name))
-	server.Wait()
-	return serverErr
+	server, serverErr := fs.Mount(r.getMountConfig().Path, r, opts)
+	if serverErr != nil {
+		return nil, serverErr
+	}
+	log.Debug(fmt.Sprintf("fs.Mount called for '%s'", r.name))
+	r.server = server
+	r.settings.setMounted(true)
+	return server, nil
+}
+
+// Unmount tries to unmount the root until it succeeds or the deadline passes. Unmounting fails
+// if any process is still using the mount.
+func (r *root) Unmount(deadline time.Time) error {
+	if r.server == nil {
+		return nil
+	}
+	for {
+		unmountErr := r.server.Unmount()
+		if unmountErr == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Unable to unmount '%s': %w", r.name, unmountErr)
+		}
+		log.Warn(fmt.Sprintf("Unable to unmount '%s', will retry: %v", r.name, unmountErr))
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// StopCommands stops the root's command runner pool, killing commands still running after the
+// grace period, and then the mount's coprocess. Returns the number of commands that were killed.
+func (r *root) StopCommands(gracePeriod time.Duration) int {
+	defer r.settings.stopCoprocess()
+	if r.commandRunnerPool == nil {
+		return 0
+	}
+	return r.commandRunnerPool.Stop(gracePeriod)
+}
+
+func (r *root) GetName() string {
+	return r.name
 }
 
 func (r *root) OnAdd(ctx context.Context) {
 	curTime := time.Now()
 	r.attr = &fuse.Attr{}
 	r.attr.SetTimes(&curTime, &curTime, &curTime)
-	noThreads := r.config.ThreadCount
+	noThreads := r.getMountConfig().ThreadCount
 	if noThreads == 0 {
 		noThreads = uint(runtime.NumCPU())
 	}
 	r.commandRunnerPool = command.NewPool(int(noThreads))
 	r.commandRunnerPool.Start()
-	var wg sync.WaitGroup
-	err := loadChildren(ctx, r, &wg)
-	wg.Wait()
+	err := loadChildren(ctx, r)
 	if err != nil {
 		log.Error(err.Error())
 	}
@@ -70,53 +126,109 @@ func (r *root) getAttr() *fuse.Attr {
 }
 
 func (r *root) isContentStale() bool {
+	// Listings that depend on the caller are not cached since the tree is shared by all callers
+	if r.settings.getTemplates().root.UsesCaller() {
+		return true
+	}
 	return isContentStale(r)
 }
 
 func (r *root) getCacheSeconds() uint64 {
-	return r.config.CacheSeconds
+	return r.getMountConfig().CacheSeconds
 }
 
 func (r *root) shouldCache() bool {
-	return r.config.Cache
+	return r.getMountConfig().Cache
+}
+
+func (r *root) getSettings() *settings {
+	return r.settings
+}
+
+func (r *root) getMountConfig() config.Mount {
+	return r.settings.getMountConfig()
 }
 
 func (r *root) getDirectoryConfig() config.Directory {
-	return r.config.Directory
+	return r.settings.getDirectoryConfig()
+}
+
+// Reconfigure applies the provided configuration to the root and all its descendants, and
+// invalidates their cached content. The mount's path and thread count are not changed. If the
+// configuration is invalid, the current configuration is kept and an error is returned.
+func (r *root) Reconfigure(conf config.Mount) error {
+	log.Info(fmt.Sprintf("Applying new configuration to '%s'", r.name))
+	if setErr := r.settings.setMountConfig(conf); setErr != nil {
+		return fmt.Errorf("Invalid configuration for mount '%s': %w", r.name, setErr)
+	}
+	invalidateTree(&r.Inode)
+	return nil
+}
+
+func (r *root) getLoadedAt() time.Time {
+	return r.loadedAt
 }
 
-func (r *root) getFileConfig() config.File {
-	return r.config.File
+func (r *root) setLoadedAt(loadedAt time.Time) {
+	r.loadedAt = loadedAt
+}
+
+func (r *root) invalidate() {
+	invalidate(r)
+	r.setCachedTestRunOutput([]byte{})
 }
 
 func (r *root) getInode() *fs.Inode {
 	return &r.Inode
 }
 
-func (r *root) getReadCommand() (string, error) {
-	if len(r.config.ReadCommand) > 0 {
-		return r.config.ReadCommand, nil
-	}
-	if len(r.config.Directory.ReadCommand) > 0 {
-		return r.config.Directory.ReadCommand, nil
+func (r *root) getReadCommand() (command.Template, error) {
+	if rootTemplate := r.settings.getTemplates().root; rootTemplate.IsSet() {
+		return rootTemplate, nil
 	}
 
-	return "", errors.New("Read command not provided for mount root")
+	return command.Template{}, errors.New("Read command not provided for mount root")
 }
 
 func (r *root) getNameSeparator() (string, error) {
-	if len(r.config.NameSeparator) > 0 {
-		return r.config.NameSeparator, nil
+	mountConfig := r.getMountConfig()
+	if r.settings.hasBackend() {
+		return command.BackendNameSeparator, nil
 	}
-	if len(r.config.Directory.NameSeparator) > 0 {
-		return r.config.Directory.NameSeparator, nil
+	if len(mountConfig.NameSeparator) > 0 {
+		return mountConfig.NameSeparator, nil
+	}
+	if len(mountConfig.Directory.NameSeparator) > 0 {
+		return mountConfig.Directory.NameSeparator, nil
 	}
 
 	return "", errors.New("Name separator not provided for mount root")
 }
 
+// getListFormat returns the format the root's read command lists entries in. Falls back to the
+// directory's format.
+func (r *root) getListFormat() string {
+	if r.settings.hasBackend() {
+		return listFormatText
+	}
+	mountConfig := r.getMountConfig()
+	if len(mountConfig.ListFormat) > 0 {
+		return getListFormat(mountConfig.ListFormat)
+	}
+	return getListFormat(mountConfig.Directory.ListFormat)
+}
+
+func (r *root) getTimeout() time.Duration {
+	mountConfig := r.getMountConfig()
+	if mountConfig.TimeoutSeconds > 0 {
+		return getTimeout(mountConfig.TimeoutSeconds)
+	}
+
+	return getTimeout(mountConfig.Directory.TimeoutSeconds)
+}
+
 func (r *root) getCommandState() *command.State {
-	return command.NewState(r.name, r.config.Path, "", "")
+	return command.NewState(r.name, r.getMountConfig().Path, "", "")
 }
 
 func (r *root) getCommandRunnerPool() *command.Pool {
@@ -129,25 +241,22 @@ func (r *root) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut)
 }
 
 func (r *root) getattr(out *fuse.AttrOut) {
-	out.Mode = r.config.Mode
+	out.Mode = r.getMountConfig().Mode
 	out.Mtime = r.attr.Mtime
 	out.Ctime = r.attr.Ctime
 	out.Atime = r.attr.Atime
 }
 
-func (r *root) Release(ctx context.Context, f fs.FileHandle) syscall.Errno {
-	r.commandRunnerPool.Stop()
-	// TODO: umount
-	return 0
-}
-
 func (r *root) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
 	log.Debug("Readdir called for root")
-	var wg sync.WaitGroup
-	loadErr := loadChildren(ctx, r, &wg)
-	wg.Wait()
+	defer observeOperation(r.name, "Readdir", time.Now())
+	if errno := checkAccess(ctx, r.settings, r.getCommandState(), "Readdir", nil); errno != 0 {
+		return nil, errno
+	}
+	loadErr := loadChildren(ctx, r)
 	if loadErr != nil {
 		log.Error(loadErr.Error())
+		return nil, getFailureErrno(loadErr, r.getDirectoryConfig().ExitCodeErrno)
 	}
 	r.attr.Atime = uint64(time.Now().Unix())
 	for childName, chidInode := range r.Children() {
@@ -170,13 +279,32 @@ func (r *root) setCachedTestRunOutput(testRunOutput []byte) {
 
 func (r *root) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
 	log.Debug("Lookup called for root")
-	return lookupChild(ctx, r, name)
+	defer observeOperation(r.name, "Lookup", time.Now())
+	return lookupChild(ctx, r, name, out)
 }
 
 func (r *root) getChildren() map[string]*fs.Inode {
 	return r.Children()
 }
 
+func (r *root) getLastCommand() *commandResult {
+	return r.lastCommand
+}
+
+func (r *root) Getxattr(ctx context.Context, attr string, dest []byte) (uint32, syscall.Errno) {
+	if errno := checkAccess(ctx, r.settings, r.getCommandState(), "Getxattr", nil); errno != 0 {
+		return 0, errno
+	}
+	return getResultXattr(r.lastCommand, attr, dest)
+}
+
+func (r *root) Listxattr(ctx context.Context, dest []byte) (uint32, syscall.Errno) {
+	if errno := checkAccess(ctx, r.settings, r.getCommandState(), "Listxattr", nil); errno != 0 {
+		return 0, errno
+	}
+	return listResultXattrs(r.lastCommand, dest)
+}
+
 func (r *root) HasNext() bool {
 	return r.dirEntryPointer < len(r.dirEntries)
 }
@@ -193,9 +321,10 @@ func (r *root) Close() {
 	r.dirEntryPointer = 0
 }
 
-var _ = (fs.NodeGetattrer)((*root)(nil)) // Contains Getattr
-var _ = (fs.NodeOnAdder)((*root)(nil))   // Contains OnAdd
-var _ = (fs.DirStream)((*root)(nil))     // Contains HasNext, Next, and Close
-var _ = (fs.NodeLookuper)((*root)(nil))  // Contains Lookup
-var _ = (fs.NodeReaddirer)((*root)(nil)) // Contains Readdir
-var _ = (fs.NodeReleaser)((*root)(nil))  // Contains Release
+var _ = (fs.NodeGetattrer)((*root)(nil))   // Contains Getattr
+var _ = (fs.NodeOnAdder)((*root)(nil))     // Contains OnAdd
+var _ = (fs.DirStream)((*root)(nil))       // Contains HasNext, Next, and Close
+var _ = (fs.NodeLookuper)((*root)(nil))    // Contains Lookup
+var _ = (fs.NodeReaddirer)((*root)(nil))   // Contains Readdir
+var _ = (fs.NodeGetxattrer)((*root)(nil))  // Contains Getxattr
+var _ = (fs.NodeListxattrer)((*root)(nil)) // Contains Listxattr
@@ -0,0 +1,296 @@
+package mount
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/jasonrogena/fusee/internal/app/fusee/config"
+	"github.com/jasonrogena/fusee/internal/pkg/command"
+	log "github.com/sirupsen/logrus"
+)
+
+// settings holds the configuration shared by all the nodes in a mount. The configuration can be
+// swapped while the mount is live.
+type settings struct {
+	name      string
+	config    config.Mount
+	templates templates
+	// Is nil if the mount does not use a coprocess
+	coprocess *command.Coprocess
+	// Set if the mount's commands are sent to a backend provided when the mount was created
+	backend command.Backend
+	// Whether the mount is mounted, and the kernel can consequently be notified of changes. Is
+	// false when the mount's tree is only rendered
+	mounted bool
+	mutex   *sync.RWMutex
+}
+
+// templates holds the parsed command templates of a mount.
+type templates struct {
+	root      command.Template
+	directory command.Template
+	file      command.Template
+	// The directory's typeCommand and lookupCommand
+	directoryType   command.Template
+	directoryLookup command.Template
+}
+
+// newSettings returns the settings for the mount. If backend is not nil, all the mount's commands
+// are sent to it.
+func newSettings(name string, conf config.Mount, backend command.Backend) (*settings, error) {
+	parsedTemplates, parseErr := parseTemplates(conf)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	s := &settings{
+		name:    name,
+		config:  conf,
+		backend: backend,
+		mutex:   new(sync.RWMutex),
+	}
+	if backend == nil {
+		s.coprocess = newCoprocess(name, conf)
+	}
+	s.templates = withBackend(parsedTemplates, s.getBackendLocked())
+
+	return s, nil
+}
+
+// newCoprocess returns the coprocess configured for the mount. Returns nil if the mount does not
+// use a coprocess.
+func newCoprocess(name string, conf config.Mount) *command.Coprocess {
+	if len(conf.Coprocess) == 0 {
+		return nil
+	}
+
+	return command.NewCoprocess(name, conf.Coprocess, conf.Env, conf.WorkingDir, conf.CleanEnv, getStderrLogLevel(conf))
+}
+
+// hasSameCoprocess returns true if the coprocess configured for both mounts is run the same way,
+// so that a running coprocess can be kept when the configuration is swapped.
+func hasSameCoprocess(conf config.Mount, other config.Mount) bool {
+	return reflect.DeepEqual(conf.Coprocess, other.Coprocess) &&
+		reflect.DeepEqual(conf.Env, other.Env) &&
+		conf.WorkingDir == other.WorkingDir &&
+		conf.CleanEnv == other.CleanEnv &&
+		getStderrLogLevel(conf) == getStderrLogLevel(other)
+}
+
+// withBackend returns the templates with their commands sent to the backend. The templates are
+// returned as is if the backend is nil.
+func withBackend(parsedTemplates templates, backend command.Backend) templates {
+	if backend == nil {
+		return parsedTemplates
+	}
+	parsedTemplates.root.Backend = backend
+	parsedTemplates.directory.Backend = backend
+	parsedTemplates.file.Backend = backend
+
+	return parsedTemplates
+}
+
+// getBackendLocked returns the backend the mount's commands are sent to, or nil if they are run
+// as processes. Should be called with the mutex locked.
+func (s *settings) getBackendLocked() command.Backend {
+	if s.backend != nil {
+		return s.backend
+	}
+	if s.coprocess != nil {
+		return s.coprocess
+	}
+
+	return nil
+}
+
+// hasBackend returns true if the mount's commands are sent to a backend instead of being run as
+// processes. Backends list entries separated by command.BackendNameSeparator.
+func (s *settings) hasBackend() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.getBackendLocked() != nil
+}
+
+// parseTemplates parses the command templates in the mount's configuration. The returned error
+// names the field with the template that could not be parsed.
+func parseTemplates(conf config.Mount) (templates, error) {
+	parsedTemplates := templates{}
+	var parseErr error
+	parsedTemplates.root, parseErr = parseTemplate("readCommand", "readArgs", command.Template{
+		Shell:      conf.ReadCommand,
+		Args:       conf.ReadArgs,
+		Env:        conf.Env,
+		WorkingDir: conf.WorkingDir,
+		CleanEnv:   conf.CleanEnv,
+		PerCaller:  conf.PerCaller,
+	})
+	if parseErr != nil {
+		return templates{}, parseErr
+	}
+	parsedTemplates.directory, parseErr = parseTemplate("directory.readCommand", "directory.readArgs", command.Template{
+		Shell:      conf.Directory.ReadCommand,
+		Args:       conf.Directory.ReadArgs,
+		Env:        mergeEnv(conf.Env, conf.Directory.Env),
+		WorkingDir: getWorkingDir(conf.WorkingDir, conf.Directory.WorkingDir),
+		CleanEnv:   conf.CleanEnv,
+		PerCaller:  conf.Directory.PerCaller,
+	})
+	if parseErr != nil {
+		return templates{}, parseErr
+	}
+	parsedTemplates.directoryType, parseErr = parseTemplate("directory.typeCommand", "", command.Template{
+		Shell:      conf.Directory.TypeCommand,
+		Env:        mergeEnv(conf.Env, conf.Directory.Env),
+		WorkingDir: getWorkingDir(conf.WorkingDir, conf.Directory.WorkingDir),
+		CleanEnv:   conf.CleanEnv,
+		PerCaller:  conf.Directory.PerCaller,
+	})
+	if parseErr != nil {
+		return templates{}, parseErr
+	}
+	parsedTemplates.directoryLookup, parseErr = parseTemplate("directory.lookupCommand", "", command.Template{
+		Shell:      conf.Directory.LookupCommand,
+		Env:        mergeEnv(conf.Env, conf.Directory.Env),
+		WorkingDir: getWorkingDir(conf.WorkingDir, conf.Directory.WorkingDir),
+		CleanEnv:   conf.CleanEnv,
+		PerCaller:  conf.Directory.PerCaller,
+	})
+	if parseErr != nil {
+		return templates{}, parseErr
+	}
+	parsedTemplates.file, parseErr = parseTemplate("file.readCommand", "file.readArgs", command.Template{
+		Shell:      conf.File.ReadCommand,
+		Args:       conf.File.ReadArgs,
+		Env:        mergeEnv(conf.Env, conf.File.Env),
+		WorkingDir: getWorkingDir(conf.WorkingDir, conf.File.WorkingDir),
+		CleanEnv:   conf.CleanEnv,
+		PerCaller:  conf.File.PerCaller,
+	})
+	if parseErr != nil {
+		return templates{}, parseErr
+	}
+	if !parsedTemplates.root.IsSet() {
+		// The root falls back to the directory's command
+		parsedTemplates.root = parsedTemplates.directory
+	}
+
+	return parsedTemplates, nil
+}
+
+// mergeEnv returns the mount's environment variables with the node's variables added, overriding
+// the mount's.
+func mergeEnv(mountEnv map[string]string, nodeEnv map[string]string) map[string]string {
+	env := map[string]string{}
+	for curName, curValue := range mountEnv {
+		env[curName] = curValue
+	}
+	for curName, curValue := range nodeEnv {
+		env[curName] = curValue
+	}
+
+	return env
+}
+
+func getWorkingDir(mountWorkingDir string, nodeWorkingDir string) string {
+	if len(nodeWorkingDir) > 0 {
+		return nodeWorkingDir
+	}
+
+	return mountWorkingDir
+}
+
+func parseTemplate(shellKey string, argsKey string, unparsed command.Template) (command.Template, error) {
+	parsed, parseErr := unparsed.Parse()
+	if parseErr != nil {
+		key := shellKey
+		if len(unparsed.Args) > 0 {
+			key = argsKey
+		}
+		return command.Template{}, fmt.Errorf("Unable to parse %s: %w", key, parseErr)
+	}
+
+	return parsed, nil
+}
+
+func (s *settings) getMountConfig() config.Mount {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.config
+}
+
+// setMountConfig swaps the mount's configuration. The configuration is not swapped if any of its
+// command templates cannot be parsed. The mount's coprocess is restarted if the way it is run
+// changed.
+func (s *settings) setMountConfig(conf config.Mount) error {
+	parsedTemplates, parseErr := parseTemplates(conf)
+	if parseErr != nil {
+		return parseErr
+	}
+	s.mutex.Lock()
+	oldCoprocess := s.coprocess
+	if s.backend == nil && !hasSameCoprocess(s.config, conf) {
+		s.coprocess = newCoprocess(s.name, conf)
+	}
+	s.config = conf
+	s.templates = withBackend(parsedTemplates, s.getBackendLocked())
+	s.mutex.Unlock()
+	if oldCoprocess != nil && oldCoprocess != s.coprocess {
+		oldCoprocess.Stop()
+	}
+
+	return nil
+}
+
+func (s *settings) setMounted(mounted bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.mounted = mounted
+}
+
+func (s *settings) isMounted() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.mounted
+}
+
+// stopCoprocess stops the mount's coprocess, if it has one.
+func (s *settings) stopCoprocess() {
+	s.mutex.RLock()
+	coprocess := s.coprocess
+	s.mutex.RUnlock()
+	if coprocess != nil {
+		coprocess.Stop()
+	}
+}
+
+func (s *settings) getTemplates() templates {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.templates
+}
+
+func (s *settings) getDirectoryConfig() config.Directory {
+	return s.getMountConfig().Directory
+}
+
+func (s *settings) getFileConfig() config.File {
+	return s.getMountConfig().File
+}
+
+// getStderrLogLevel returns the level the stderr of commands is logged at. Defaults to warning.
+func (s *settings) getStderrLogLevel() log.Level {
+	return getStderrLogLevel(s.getMountConfig())
+}
+
+func getStderrLogLevel(conf config.Mount) log.Level {
+	levelName := conf.StderrLogLevel
+	if len(levelName) == 0 {
+		return log.WarnLevel
+	}
+	level, parseErr := log.ParseLevel(levelName)
+	if parseErr != nil {
+		return log.WarnLevel
+	}
+
+	return level
+}
@@ -0,0 +1,100 @@
+package mount
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/jasonrogena/fusee/internal/pkg/command"
+)
+
+// NodeStatus describes the state of a node in a mount's tree.
+type NodeStatus struct {
+	// The path of the node relative to the mount's root
+	Path string `json:"path"`
+	Type string `json:"type"`
+	// Whether the node's content has been loaded since it was added or last invalidated
+	Loaded bool `json:"loaded"`
+	// The number of seconds since the node's content was last loaded
+	CacheAgeSeconds uint64 `json:"cacheAgeSeconds"`
+	Stale           bool   `json:"stale"`
+	// The exit status and stderr of the last command run to load the node's content
+	LastExitStatus string `json:"lastExitStatus,omitempty"`
+	LastStderr     string `json:"lastStderr,omitempty"`
+}
+
+// GetTree returns the status of the root and all the nodes loaded under it.
+func (r *root) GetTree() []NodeStatus {
+	statuses := []NodeStatus{}
+	return appendNodeStatus(statuses, "", &r.Inode)
+}
+
+func appendNodeStatus(statuses []NodeStatus, path string, node *fs.Inode) []NodeStatus {
+	nodeCache, isCache := node.Operations().(cache)
+	if !isCache {
+		return statuses
+	}
+	status := NodeStatus{
+		Path:   path,
+		Type:   "file",
+		Loaded: !nodeCache.getLoadedAt().IsZero(),
+		Stale:  isContentStale(nodeCache),
+	}
+	if node.IsDir() {
+		status.Type = "dir"
+	}
+	if status.Loaded {
+		status.CacheAgeSeconds = uint64(time.Since(nodeCache.getLoadedAt()) / time.Second)
+	}
+	if resultHolder, ok := node.Operations().(commandResultHolder); ok {
+		exitStatus, stderr := resultHolder.getLastCommand().get()
+		status.LastExitStatus = exitStatus
+		status.LastStderr = string(stderr)
+	}
+	statuses = append(statuses, status)
+
+	children := node.Children()
+	names := []string{}
+	for curName := range children {
+		names = append(names, curName)
+	}
+	sort.Strings(names)
+	for _, curName := range names {
+		childPath := curName
+		if len(path) > 0 {
+			childPath = path + string(os.PathSeparator) + curName
+		}
+		statuses = appendNodeStatus(statuses, childPath, children[curName])
+	}
+
+	return statuses
+}
+
+// Invalidate invalidates the cached content of the node at path, relative to the mount's root,
+// and all the node's descendants. If path is blank, the whole mount is invalidated.
+func (r *root) Invalidate(path string) error {
+	node := &r.Inode
+	for _, curName := range strings.Split(path, string(os.PathSeparator)) {
+		if len(curName) == 0 {
+			continue
+		}
+		node = node.GetChild(curName)
+		if node == nil {
+			return fmt.Errorf("'%s' has not been loaded in '%s'", path, r.name)
+		}
+	}
+	invalidateTree(node)
+
+	return nil
+}
+
+// GetCommandStats returns the stats for each of the runners in the root's command runner pool.
+func (r *root) GetCommandStats() []command.RunnerStats {
+	if r.commandRunnerPool == nil {
+		return []command.RunnerStats{}
+	}
+	return r.commandRunnerPool.GetStats()
+}
@@ -0,0 +1,71 @@
+package mount
+
+import (
+	"context"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/jasonrogena/fusee/internal/pkg/command"
+	log "github.com/sirupsen/logrus"
+)
+
+// The mode of symlinks not given one by the JSON listing they were in
+const defaultSymlinkMode = 0o777
+
+// symlink is a symbolic link listed in a JSON listing. Its target is not resolved by fusee.
+type symlink struct {
+	fs.Inode
+	commandState *command.State
+	target       string
+	// Attributes set by the JSON listing the symlink was in
+	entryAttr entryAttr
+	// Guards the target and attributes, which are updated when the symlink is listed again
+	mutex *sync.Mutex
+}
+
+func NewSymlink(commandState *command.State, target string, attr entryAttr) *symlink {
+	return &symlink{
+		commandState: commandState,
+		target:       target,
+		entryAttr:    attr,
+		mutex:        new(sync.Mutex),
+	}
+}
+
+func (s *symlink) get() (string, entryAttr) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.target, s.entryAttr
+}
+
+func (s *symlink) set(target string, attr entryAttr) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.target = target
+	s.entryAttr = attr
+}
+
+func (s *symlink) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	log.Debug("Readlink called for symlink")
+	target, _ := s.get()
+	return []byte(target), 0
+}
+
+func (s *symlink) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	log.Debug("Getattr called for symlink")
+	s.getattr(out)
+	return 0
+}
+
+func (s *symlink) getattr(out *fuse.AttrOut) {
+	target, attr := s.get()
+	out.Mode = defaultSymlinkMode
+	out.Size = uint64(len(target))
+	attr.apply(out)
+}
+
+var _ = (fs.InodeEmbedder)((*symlink)(nil))
+var _ = (fs.NodeReadlinker)((*symlink)(nil)) // Contains Readlink
+var _ = (fs.NodeGetattrer)((*symlink)(nil))  // Contains Getattr
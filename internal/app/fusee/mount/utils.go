@@ -2,8 +2,10 @@ package mount
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -20,23 +22,30 @@ import (
 type parent interface {
 	getCommandState() *command.State
 	getInode() *fs.Inode
-	getReadCommand() (string, error)
+	getReadCommand() (command.Template, error)
 	getNameSeparator() (string, error)
+	getListFormat() string
+	getTimeout() time.Duration
+	getSettings() *settings
 	getDirectoryConfig() config.Directory
-	getFileConfig() config.File
 	isContentStale() bool
-	getAttr() *fuse.Attr
 	getCommandRunnerPool() *command.Pool
 	getCachedTestRunOutput() []byte
 	setCachedTestRunOutput(testRunOutput []byte)
 	getChildren() map[string]*fs.Inode
+	getLastCommand() *commandResult
+	cache
 }
 
-func loadChildren(ctx context.Context, r parent, wg *sync.WaitGroup) error {
+// loadChildren runs the parent's read command, if its content is stale, and adds the entries
+// it lists as the parent's children.
+func loadChildren(ctx context.Context, r parent) error {
 	log.Debug(fmt.Sprintf("loadChildren called on '%s'", r.getCommandState().RelativePath))
 	log.Debug(fmt.Sprintf("Number of children before loading children is %d", len(r.getInode().Children())))
 	defer log.Debug(fmt.Sprintf("Number of children after loading children is %d", len(r.getInode().Children())))
-	if !r.isContentStale() {
+	isStale := r.isContentStale()
+	observeCacheRequest(r.getCommandState().MountName, "dir", !isStale)
+	if !isStale {
 		log.Debug("Content is not yet stale, not running command")
 		if len(r.getCachedTestRunOutput()) > 0 {
 			log.Debug(fmt.Sprintf("Using the output for the command used to test whether '%s' is a directory to build its dirents", r.getCommandState().RelativePath))
@@ -48,30 +57,68 @@ func loadChildren(ctx context.Context, r parent, wg *sync.WaitGroup) error {
 
 	log.Info("Running command to get dirents for ",
 		r.getCommandState().MountRootDirPath+string(os.PathSeparator)+r.getCommandState().RelativePath)
-	r.getAttr().Mtime = uint64(time.Now().Unix())
+	markLoaded(r)
 	readCommand, readCommandErr := r.getReadCommand()
 	if readCommandErr != nil {
 		return readCommandErr
 	}
+	var wg sync.WaitGroup
+	var listOutput []byte
+	var loadErr error
 	wg.Add(1)
-	r.getCommandRunnerPool().AddCommand(command.NewCommand(readCommand, r.getCommandState(), func(commandOutput []byte, commandErr error) {
+	r.getCommandRunnerPool().AddCommand(command.NewCommand(ctx, command.KindList, readCommand, r.getTimeout(), fuseefs.WithCaller(ctx, r.getCommandState()), func(commandOutput []byte, stderr []byte, commandErr error) {
+		defer wg.Done()
+		recordCommandResult(r.getSettings(), r.getLastCommand(), r.getCommandState().RelativePath, stderr, commandErr)
 		if commandErr != nil {
-			log.Warn(fmt.Sprintf("Unable to load direntries for '%s' due to an error: %v", r.getCommandState().RelativePath, commandErr))
+			// Don't cache the failed attempt
+			r.invalidate()
+			loadErr = fmt.Errorf("Unable to load direntries for '%s' due to an error: %w", r.getCommandState().RelativePath, commandErr)
 			return
 		}
-		loadCommandOutput(ctx, r, commandOutput)
-		wg.Done()
+		listOutput = commandOutput
 	}))
+	wg.Wait()
+	if loadErr != nil {
+		return loadErr
+	}
+	// Loaded outside the postRunHook since probing the entries queues more commands in the pool
+	loadCommandOutput(ctx, r, listOutput)
 	return nil
 }
 
-func lookupChild(ctx context.Context, r parent, name string) (*fs.Inode, syscall.Errno) {
+// lookupChild looks up the parent's child with the provided name and sets the child's attributes
+// in out, since the kernel uses them instead of calling Getattr until they expire.
+func lookupChild(ctx context.Context, r parent, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	child, errno := findChild(ctx, r, name)
+	if errno != 0 {
+		return nil, errno
+	}
+	if node, isRenderable := child.Operations().(renderable); isRenderable {
+		attrOut := fuse.AttrOut{}
+		node.getattr(&attrOut)
+		out.Attr = attrOut.Attr
+	}
+
+	return child, 0
+}
+
+// findChild returns the parent's child with the provided name, running the commands needed to
+// find it if the parent's content is stale or the child is not cached.
+func findChild(ctx context.Context, r parent, name string) (*fs.Inode, syscall.Errno) {
+	if errno := checkAccess(ctx, r.getSettings(), r.getCommandState(), "Lookup", nil); errno != 0 {
+		return nil, errno
+	}
 	if !r.isContentStale() {
 		child, childFound := r.getChildren()[name]
 		if childFound {
+			observeCacheRequest(r.getCommandState().MountName, "dir", true)
 			return child, 0
 		}
 	}
+	observeCacheRequest(r.getCommandState().MountName, "dir", false)
+	if lookupTemplate := r.getSettings().getTemplates().directoryLookup; lookupTemplate.IsSet() {
+		return lookupChildUsingCommand(ctx, r, name, lookupTemplate)
+	}
 
 	readCommand, readCommandErr := r.getReadCommand()
 	if readCommandErr != nil {
@@ -81,25 +128,77 @@ func lookupChild(ctx context.Context, r parent, name string) (*fs.Inode, syscall
 
 	log.Info(fmt.Sprintf("Running command to lookup '%s' in '%s'", name, r.getCommandState().RelativePath))
 	var wg sync.WaitGroup
+	var listOutput []byte
+	var lookupErr error
 	wg.Add(1)
-	r.getCommandRunnerPool().AddCommand(command.NewCommand(readCommand, r.getCommandState(), func(commandOutput []byte, commandErr error) {
+	r.getCommandRunnerPool().AddCommand(command.NewCommand(ctx, command.KindList, readCommand, r.getTimeout(), fuseefs.WithCaller(ctx, r.getCommandState()), func(commandOutput []byte, stderr []byte, commandErr error) {
 		defer wg.Done()
-		r.setCachedTestRunOutput(commandOutput)
-		r.getAttr().Mtime = uint64(time.Now().Unix())
-		separator, separatorErr := r.getNameSeparator()
-		if separatorErr != nil {
-			log.Warn(fmt.Sprintf("Unable to lookup dir '%s' due to an error: %v", r.getCommandState().RelativePath, separatorErr))
+		recordCommandResult(r.getSettings(), r.getLastCommand(), r.getCommandState().RelativePath, stderr, commandErr)
+		if commandErr != nil {
+			lookupErr = commandErr
 			return
 		}
-		names := strings.Split(string(commandOutput[:]), separator)
-		for _, curFileName := range names {
-			if curFileName == name {
-				addDirent(ctx, r, curFileName)
-				break
-			}
+		listOutput = commandOutput
+	}))
+	wg.Wait()
+	if lookupErr != nil {
+		log.Warn(fmt.Sprintf("Unable to lookup '%s' in '%s' due to an error: %v", name, r.getCommandState().RelativePath, lookupErr))
+		return nil, getFailureErrno(lookupErr, r.getDirectoryConfig().ExitCodeErrno)
+	}
+	r.setCachedTestRunOutput(listOutput)
+	markLoaded(r)
+	entries, listingErr := getListing(r, listOutput)
+	if listingErr != nil {
+		log.Warn(fmt.Sprintf("Unable to lookup dir '%s' due to an error: %v", r.getCommandState().RelativePath, listingErr))
+		return nil, syscall.ENOENT
+	}
+	for _, curEntry := range entries {
+		if curEntry.Name == name {
+			var probeWg sync.WaitGroup
+			addEntry(ctx, r, curEntry, &probeWg)
+			probeWg.Wait()
+			break
+		}
+	}
+
+	child, childFound := r.getChildren()[name]
+	if childFound {
+		return child, 0
+	}
+
+	return nil, syscall.ENOENT
+}
+
+// lookupChildUsingCommand looks up the child with the provided name by running the directory's
+// lookupCommand against it, instead of listing all of the parent's entries. The child does not
+// exist if the command exits with a non-zero exit code.
+func lookupChildUsingCommand(ctx context.Context, r parent, name string, lookupTemplate command.Template) (*fs.Inode, syscall.Errno) {
+	commandState := getChildState(r, name)
+	log.Info(fmt.Sprintf("Running lookup command for '%s'", commandState.RelativePath))
+	var wg sync.WaitGroup
+	var lookupErr error
+	added := false
+	wg.Add(1)
+	r.getCommandRunnerPool().AddCommand(command.NewCommand(ctx, command.KindLookup, lookupTemplate, r.getTimeout(), fuseefs.WithCaller(ctx, commandState), func(commandOutput []byte, stderr []byte, commandErr error) {
+		defer wg.Done()
+		recordCommandResult(r.getSettings(), r.getLastCommand(), commandState.RelativePath, stderr, commandErr)
+		if commandErr != nil {
+			lookupErr = commandErr
+			return
 		}
+		added = addTypedChild(ctx, r, commandState, commandOutput)
 	}))
 	wg.Wait()
+	if lookupErr != nil {
+		if exitCode, exited := command.GetExitCode(lookupErr); exited && exitCode != 0 {
+			return nil, syscall.ENOENT
+		}
+		log.Warn(fmt.Sprintf("Unable to lookup '%s' due to an error: %v", commandState.RelativePath, lookupErr))
+		return nil, getFailureErrno(lookupErr, r.getDirectoryConfig().ExitCodeErrno)
+	}
+	if !added {
+		return nil, syscall.EIO
+	}
 
 	child, childFound := r.getChildren()[name]
 	if childFound {
@@ -110,91 +209,364 @@ func lookupChild(ctx context.Context, r parent, name string) (*fs.Inode, syscall
 }
 
 func loadCommandOutput(ctx context.Context, r parent, commandOutput []byte) {
-	separator, separatorErr := r.getNameSeparator()
-	if separatorErr != nil {
-		log.Warn(fmt.Sprintf("Unable to load direntries for '%s' due to an error: %v", r.getCommandState().RelativePath, separatorErr))
+	entries, listingErr := getListing(r, commandOutput)
+	if listingErr != nil {
+		log.Warn(fmt.Sprintf("Unable to load direntries for '%s' due to an error: %v", r.getCommandState().RelativePath, listingErr))
+		return
+	}
+	removeVanishedChildren(r, entries)
+	// Entries that need to be probed are probed in parallel, bounded by the size of the pool
+	var probeWg sync.WaitGroup
+	for _, curEntry := range entries {
+		addEntry(ctx, r, curEntry, &probeWg)
+	}
+	probeWg.Wait()
+}
+
+// removeVanishedChildren removes the parent's children that are not in the listing, and notifies
+// the kernel that they were removed.
+func removeVanishedChildren(r parent, entries []listEntry) {
+	listedNames := map[string]bool{}
+	for _, curEntry := range entries {
+		listedNames[strings.TrimSpace(curEntry.Name)] = true
+	}
+	for curName, curChild := range r.getChildren() {
+		if listedNames[curName] {
+			continue
+		}
+		log.Info(fmt.Sprintf("Removing '%s' from '%s' since it is no longer listed", curName, r.getCommandState().RelativePath))
+		r.getInode().RmChild(curName)
+		child := curChild
+		notifyKernel(r.getSettings(), curName, func(name string) syscall.Errno {
+			return r.getInode().NotifyDelete(name, child)
+		})
+	}
+}
+
+// replaceChild adds ch as the parent's child with the provided name, replacing any existing child
+// with the name. If the existing child is of a different type, for instance a file that is now a
+// directory, the kernel is notified so that it does not keep using the existing child. The kernel
+// is also notified of new children if it caches lookups of names that do not exist.
+func replaceChild(r parent, name string, ch *fs.Inode) bool {
+	previous := r.getInode().GetChild(name)
+	success := r.getInode().AddChild(name, ch, true)
+	if !success {
+		return false
+	}
+	if previous != nil && previous.StableAttr().Mode != ch.StableAttr().Mode {
+		log.Info(fmt.Sprintf("Replacing '%s' in '%s' since its type changed", name, r.getCommandState().RelativePath))
+		notifyKernel(r.getSettings(), name, r.getInode().NotifyEntry)
+	} else if previous == nil && r.getSettings().getMountConfig().NegativeTimeoutSeconds > 0 {
+		notifyKernel(r.getSettings(), name, r.getInode().NotifyEntry)
+	}
+
+	return true
+}
+
+// notifyKernel calls notify, which notifies the kernel that what it cached about the node with the
+// provided name has changed, if the mount is mounted. The kernel can wait for the FUSE operation
+// being handled to finish before accepting the notification, so notify is called in the
+// background.
+func notifyKernel(s *settings, name string, notify func(name string) syscall.Errno) {
+	if !s.isMounted() {
+		return
+	}
+	go func() {
+		// ENOENT is returned if the kernel has nothing cached for the node
+		if errno := notify(name); errno != 0 && errno != syscall.ENOENT {
+			log.Debug(fmt.Sprintf("Unable to notify the kernel that '%s' changed: %v", name, errno))
+		}
+	}()
+}
+
+// getListing parses the output of the parent's read command using the parent's list format.
+func getListing(r parent, commandOutput []byte) ([]listEntry, error) {
+	format := r.getListFormat()
+	separator := ""
+	if format == listFormatText {
+		var separatorErr error
+		separator, separatorErr = r.getNameSeparator()
+		if separatorErr != nil {
+			return nil, separatorErr
+		}
+	}
+
+	return parseListing(format, separator, commandOutput)
+}
+
+// addEntry adds the entry as a child of the parent. Entries listed without a type are probed to
+// find out whether they are directories, using commands queued in the parent's pool. probeWg is
+// done once the probe has finished and the entry has been added.
+func addEntry(ctx context.Context, r parent, entry listEntry, probeWg *sync.WaitGroup) {
+	if len(entry.Type) == 0 {
+		addDirent(ctx, r, entry.Name, probeWg)
 		return
 	}
-	names := strings.Split(string(commandOutput[:]), separator)
-	for _, curFileName := range names {
-		addDirent(ctx, r, curFileName)
+	log.Debug(fmt.Sprintf("Adding %s '%s'", entry.Type, entry.Name))
+	commandState := getChildState(r, entry.Name)
+	switch entry.Type {
+	case entryTypeDir:
+		addDirectoryChild(ctx, r, commandState, []byte{}, r.getCommandRunnerPool(), newEntryAttr(entry))
+	case entryTypeSymlink:
+		addSymlinkChild(ctx, r, commandState, entry.Target, newEntryAttr(entry))
+	default:
+		addFileChild(ctx, r, commandState, r.getCommandRunnerPool(), newEntryAttr(entry))
 	}
 }
 
-func addDirent(ctx context.Context, r parent, filename string) {
+// getChildState returns the command state of the parent's child with the provided name.
+func getChildState(r parent, name string) *command.State {
 	commandState := command.CopyState(r.getCommandState())
+	commandState.Name = name
+	relativePath := r.getCommandState().RelativePath
+	if len(relativePath) > 0 {
+		relativePath = relativePath + string(os.PathSeparator)
+	}
+	commandState.RelativePath = relativePath + name
+
+	return commandState
+}
+
+func addDirent(ctx context.Context, r parent, filename string, probeWg *sync.WaitGroup) {
 	filename = strings.TrimSpace(filename)
 	if len(filename) == 0 {
 		log.Debug("Could not add file with an empty name")
 		return
 	}
 	log.Debug(fmt.Sprintf("Adding dirent '%s'", filename))
-	commandState.Name = filename
-	relativePath := r.getCommandState().RelativePath
-	if len(relativePath) > 0 {
-		relativePath = relativePath + string(os.PathSeparator)
-	}
-	commandState.RelativePath = relativePath + filename
+	commandState := getChildState(r, filename)
 	dirConfig := r.getDirectoryConfig()
-	if len(dirConfig.ReadCommand) > 0 {
+	if typeTemplate := r.getSettings().getTemplates().directoryType; typeTemplate.IsSet() {
+		probeWg.Add(1)
+		r.getCommandRunnerPool().AddCommand(command.NewCommand(ctx, command.KindType, typeTemplate, r.getTimeout(), fuseefs.WithCaller(ctx, commandState), func(typeOutput []byte, typeStderr []byte, typeErr error) {
+			defer probeWg.Done()
+			logCommandStderr(r.getSettings(), commandState.RelativePath, typeStderr)
+			if typeErr == nil {
+				addTypedChild(ctx, r, commandState, typeOutput)
+				return
+			}
+			log.Warn(fmt.Sprintf("Not adding '%s' since its type could not be found: %v", commandState.RelativePath, typeErr))
+		}))
+	} else if dirTemplate := r.getSettings().getTemplates().directory; dirTemplate.IsSet() {
 		// Try test the dir command
-		command.NewCommand(dirConfig.ReadCommand, commandState, func(testOutput []byte, testOutputErr error) {
-			if testOutputErr == nil {
-				addDirectoryChild(ctx, r, commandState, testOutput, r.getCommandRunnerPool())
+		probeWg.Add(1)
+		r.getCommandRunnerPool().AddCommand(command.NewCommand(ctx, command.KindProbe, dirTemplate, getTimeout(dirConfig.TimeoutSeconds), fuseefs.WithCaller(ctx, commandState), func(testOutput []byte, testStderr []byte, testOutputErr error) {
+			defer probeWg.Done()
+			if isDir, probeErr := IsDirectoryProbe(testOutputErr); probeErr != nil {
+				log.Warn(fmt.Sprintf("Not adding '%s' since it could not be tested for whether it's a directory: %v", commandState.RelativePath, probeErr))
+			} else if isDir {
+				addDirectoryChild(ctx, r, commandState, testOutput, r.getCommandRunnerPool(), entryAttr{})
 			} else {
 				log.Debug(fmt.Sprintf("There was an error attemting to run directory command against '%s', adding it as a file instead %v", commandState.RelativePath, testOutputErr))
-				addFileChild(ctx, r, commandState, r.getCommandRunnerPool())
+				addFileChild(ctx, r, commandState, r.getCommandRunnerPool(), entryAttr{})
 			}
-		}).Run()
+		}))
 	} else { // Just treat as if dirent is a file
-		addFileChild(ctx, r, commandState, r.getCommandRunnerPool())
+		addFileChild(ctx, r, commandState, r.getCommandRunnerPool(), entryAttr{})
 	}
 }
 
-func addDirectoryChild(ctx context.Context, r parent, commandState *command.State, commandOutput []byte, commandRunnerPool *command.Pool) bool {
+// IsDirectoryProbe returns whether an entry is a directory, given the error returned by a
+// directory read command run against the entry to probe it. Entries the command exits with a
+// non-zero exit code for are files. Returns an error if the command did not exit, for instance
+// because it timed out.
+func IsDirectoryProbe(probeErr error) (bool, error) {
+	if probeErr == nil {
+		return true, nil
+	}
+	if exitCode, exited := command.GetExitCode(probeErr); exited && exitCode != 0 {
+		return false, nil
+	}
+
+	return false, probeErr
+}
+
+// addTypedChild adds the entry in commandState as a child of the parent, with the type printed by
+// a typeCommand or lookupCommand. Returns false if the type is unknown or the child could not be
+// added.
+func addTypedChild(ctx context.Context, r parent, commandState *command.State, typeOutput []byte) bool {
+	entryType, typeErr := parseEntryType(typeOutput)
+	if typeErr != nil {
+		log.Error(fmt.Sprintf("Not adding '%s': %v", commandState.RelativePath, typeErr))
+		return false
+	}
+	if entryType == entryTypeDir {
+		return addDirectoryChild(ctx, r, commandState, []byte{}, r.getCommandRunnerPool(), entryAttr{})
+	}
+
+	return addFileChild(ctx, r, commandState, r.getCommandRunnerPool(), entryAttr{})
+}
+
+// getChildOperations returns the node of the parent's child with the provided name. Returns nil if
+// the parent has no such child.
+func getChildOperations(r parent, name string) fs.InodeEmbedder {
+	child := r.getInode().GetChild(name)
+	if child == nil {
+		return nil
+	}
+
+	return child.Operations()
+}
+
+// addDirectoryChild adds a directory as the parent's child. If the parent already has a directory
+// with the name, it is kept, with its attributes updated, so that the kernel and the cached
+// content of the directory keep using the same node.
+func addDirectoryChild(ctx context.Context, r parent, commandState *command.State, commandOutput []byte, commandRunnerPool *command.Pool, attr entryAttr) bool {
+	if existing, isDirectory := getChildOperations(r, commandState.Name).(*directory); isDirectory {
+		existing.setEntryAttr(attr)
+		return true
+	}
+	dir := NewDirectory(
+		r.getSettings(),
+		commandOutput,
+		commandState,
+		commandRunnerPool,
+	)
+	dir.setEntryAttr(attr)
 	ch := r.getInode().NewInode(
 		ctx,
-		NewDirectory(
-			r.getDirectoryConfig(),
-			r.getFileConfig(),
-			commandOutput,
-			commandState,
-			commandRunnerPool,
-		),
+		dir,
 		fuseefs.GetDirectoryStableAttr(commandState))
-	success := r.getInode().AddChild(commandState.Name, ch, true)
+	success := replaceChild(r, commandState.Name, ch)
 	if success {
 		log.Debug(fmt.Sprintf("Successfully added directory '%s'", commandState.RelativePath))
 	} else {
-		log.Warn("Could not add directory '%s'", commandState.RelativePath)
+		log.Warnf("Could not add directory '%s'", commandState.RelativePath)
 	}
 	return success
 }
 
-func addFileChild(ctx context.Context, r parent, commandState *command.State, commandRunnerPool *command.Pool) bool {
+// addFileChild adds a file as the parent's child. If the parent already has a file with the name,
+// it is kept, with its attributes updated, so that its cached content is not lost.
+func addFileChild(ctx context.Context, r parent, commandState *command.State, commandRunnerPool *command.Pool, attr entryAttr) bool {
+	if existing, isFile := getChildOperations(r, commandState.Name).(*file); isFile {
+		existing.setEntryAttr(attr)
+		return true
+	}
+	f := NewFile(r.getSettings(), commandState, commandRunnerPool)
+	f.setEntryAttr(attr)
 	ch := r.getInode().NewInode(
 		ctx,
-		NewFile(r.getFileConfig(), commandState, commandRunnerPool),
+		f,
 		fuseefs.GetFileStableAttr(commandState))
-	success := r.getInode().AddChild(commandState.Name, ch, true)
+	success := replaceChild(r, commandState.Name, ch)
+	if success {
+		log.Debugf("Successfully added file '%s'", commandState.RelativePath)
+	} else {
+		log.Warnf("Could not add file '%s'", commandState.RelativePath)
+	}
+	return success
+}
+
+// addSymlinkChild adds a symlink as the parent's child. If the parent already has a symlink with
+// the name, it is kept, with its target and attributes updated.
+func addSymlinkChild(ctx context.Context, r parent, commandState *command.State, target string, attr entryAttr) bool {
+	if existing, isSymlink := getChildOperations(r, commandState.Name).(*symlink); isSymlink {
+		existing.set(target, attr)
+		return true
+	}
+	ch := r.getInode().NewInode(
+		ctx,
+		NewSymlink(commandState, target, attr),
+		fuseefs.GetSymlinkStableAttr(commandState))
+	success := replaceChild(r, commandState.Name, ch)
 	if success {
-		log.Debug("Successfully added file '%s'", commandState.RelativePath)
+		log.Debugf("Successfully added symlink '%s'", commandState.RelativePath)
 	} else {
-		log.Warn("Could not add file '%s'", commandState.RelativePath)
+		log.Warnf("Could not add symlink '%s'", commandState.RelativePath)
 	}
 	return success
 }
 
+// getCommandErrno returns the errno a FUSE operation should return if a command it ran timed out
+// or was interrupted. Returns 0 if err is neither.
+func getCommandErrno(err error) syscall.Errno {
+	if errors.Is(err, command.ErrTimedOut) {
+		return syscall.ETIMEDOUT
+	}
+	if errors.Is(err, command.ErrInterrupted) {
+		return syscall.EINTR
+	}
+
+	return 0
+}
+
+// The key in a node's exitCodeErrno table for the errno to use for exit codes not in the table
+const defaultExitCodeErrnoKey = "default"
+
+// getFailureErrno returns the errno a FUSE operation should return if a command it ran failed
+// with err. The exit code of the failed command is mapped to an errno using exitCodeErrno. If the
+// exit code is not in exitCodeErrno, or the command did not exit normally, the errno for the
+// "default" key is used, falling back to EIO. Returns 0 if err is nil.
+func getFailureErrno(err error, exitCodeErrno map[string]string) syscall.Errno {
+	if err == nil {
+		return 0
+	}
+	if errno := getCommandErrno(err); errno != 0 {
+		return errno
+	}
+	var backendErr *command.BackendError
+	if errors.As(err, &backendErr) && backendErr.Errno != 0 {
+		return backendErr.Errno
+	}
+
+	errnoName, found := "", false
+	if exitCode, exited := command.GetExitCode(err); exited {
+		errnoName, found = exitCodeErrno[strconv.Itoa(exitCode)]
+	}
+	if !found {
+		errnoName, found = exitCodeErrno[defaultExitCodeErrnoKey]
+	}
+	if found {
+		errno, parseErr := fuseefs.ParseErrno(errnoName)
+		if parseErr == nil {
+			return errno
+		}
+		log.Warn(parseErr.Error())
+	}
+
+	return syscall.EIO
+}
+
+func getTimeout(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
 type cache interface {
 	getAttr() *fuse.Attr
 	getCacheSeconds() uint64
 	shouldCache() bool
+	getLoadedAt() time.Time
+	setLoadedAt(loadedAt time.Time)
+	invalidate()
+}
+
+// invalidate marks the content of f as stale so that it is reloaded the next time it's accessed.
+func invalidate(f cache) {
+	f.setLoadedAt(time.Time{})
+}
+
+// markLoaded records that the content of f was loaded now, which is also its modification time.
+func markLoaded(f cache) {
+	loadedAt := time.Now()
+	f.getAttr().Mtime = uint64(loadedAt.Unix())
+	f.setLoadedAt(loadedAt)
+}
+
+// invalidateTree invalidates the cached content of the node and all its descendants.
+func invalidateTree(node *fs.Inode) {
+	if nodeCache, ok := node.Operations().(cache); ok {
+		nodeCache.invalidate()
+	}
+	for _, curChild := range node.Children() {
+		invalidateTree(curChild)
+	}
 }
 
 func isContentStale(f cache) bool {
-	if f.shouldCache() && f.getAttr().Mtime != f.getAttr().Ctime {
-		timeDiff := uint64(time.Now().Unix()) - f.getAttr().Mtime
-		log.Debug("Time difference between last mtime and now is ", timeDiff)
+	if loadedAt := f.getLoadedAt(); f.shouldCache() && !loadedAt.IsZero() {
+		timeDiff := uint64(time.Since(loadedAt) / time.Second)
+		log.Debug("Time difference between when the content was loaded and now is ", timeDiff)
 		return timeDiff > f.getCacheSeconds()
 	}
 
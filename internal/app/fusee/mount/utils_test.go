@@ -0,0 +1,237 @@
+package mount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/jasonrogena/fusee/internal/app/fusee/config"
+	"github.com/jasonrogena/fusee/internal/pkg/command"
+)
+
+// getExitErr returns the error returned by a process that exited with exitCode.
+func getExitErr(t *testing.T, exitCode int) error {
+	t.Helper()
+	runErr := exec.Command("sh", "-c", fmt.Sprintf("exit %d", exitCode)).Run()
+	if runErr == nil {
+		t.Fatalf("Expected a process exiting with %d to fail", exitCode)
+	}
+	return runErr
+}
+
+func TestGetFailureErrno(t *testing.T) {
+	exitCodeErrno := map[string]string{
+		"2":       "ENOENT",
+		"13":      "EACCES",
+		"4":       "ENOTANERRNO",
+		"default": "EPERM",
+	}
+	tests := []struct {
+		name          string
+		err           error
+		exitCodeErrno map[string]string
+		expected      syscall.Errno
+	}{
+		{"no error", nil, exitCodeErrno, 0},
+		{"mapped exit code", getExitErr(t, 2), exitCodeErrno, syscall.ENOENT},
+		{"another mapped exit code", getExitErr(t, 13), exitCodeErrno, syscall.EACCES},
+		{"wrapped exit code", fmt.Errorf("Wrapped: %w", getExitErr(t, 13)), exitCodeErrno, syscall.EACCES},
+		{"unmapped exit code uses default", getExitErr(t, 3), exitCodeErrno, syscall.EPERM},
+		{"unknown errno name", getExitErr(t, 4), exitCodeErrno, syscall.EIO},
+		{"no table", getExitErr(t, 2), nil, syscall.EIO},
+		{"did not exit uses default", errors.New("Unable to start"), exitCodeErrno, syscall.EPERM},
+		{"did not exit without default", errors.New("Unable to start"), map[string]string{"2": "ENOENT"}, syscall.EIO},
+		{"timed out", fmt.Errorf("%w after 1s", command.ErrTimedOut), exitCodeErrno, syscall.ETIMEDOUT},
+		{"interrupted", command.ErrInterrupted, exitCodeErrno, syscall.EINTR},
+		{"backend exit code", &command.BackendError{ExitCode: 2}, exitCodeErrno, syscall.ENOENT},
+		{"backend errno", &command.BackendError{ExitCode: 2, Errno: syscall.ENOTDIR}, exitCodeErrno, syscall.ENOTDIR},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			if errno := getFailureErrno(curTest.err, curTest.exitCodeErrno); errno != curTest.expected {
+				t.Errorf("Expected %v, got %v", curTest.expected, errno)
+			}
+		})
+	}
+}
+
+// getNodeType returns the type of the node, as printed by typeCommand and lookupCommand.
+func getNodeType(node *fs.Inode) string {
+	switch node.Operations().(type) {
+	case *directory:
+		return entryTypeDir
+	case *file:
+		return entryTypeFile
+	}
+
+	return ""
+}
+
+func TestLookupCommand(t *testing.T) {
+	lookup := writeTestScript(t, `case "$FUSEE_NAME" in
+dir) echo dir;;
+file) echo file;;
+link) echo symlink;;
+garbage) echo garbage;;
+slow) sleep 5; echo file;;
+*) echo "no such entry" >&2; exit 1;;
+esac
+`)
+	r := newTestRoot(t, config.Mount{
+		Path:           t.TempDir(),
+		ReadCommand:    "true",
+		NameSeparator:  "\n",
+		Mode:           0o555,
+		ThreadCount:    2,
+		Cache:          true,
+		CacheSeconds:   60,
+		TimeoutSeconds: 0.5,
+		Directory:      config.Directory{Mode: 0o555, ReadCommand: "true", LookupCommand: "sh " + lookup},
+		File:           config.File{Mode: 0o444, ReadCommand: "true"},
+	})
+	tests := []struct {
+		name               string
+		expectedType       string
+		expectedErrno      syscall.Errno
+		expectedExitStatus string
+	}{
+		{"dir", entryTypeDir, 0, "0"},
+		{"file", entryTypeFile, 0, "0"},
+		{"missing", "", syscall.ENOENT, "1"},
+		{"link", "", syscall.EIO, "0"},
+		{"garbage", "", syscall.EIO, "0"},
+		// Times out after the mount's timeout since the directory does not set one
+		{"slow", "", syscall.ETIMEDOUT, "timeout"},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			child, errno := r.Lookup(context.Background(), curTest.name, &fuse.EntryOut{})
+			if errno != curTest.expectedErrno {
+				t.Fatalf("Expected %v, got %v", curTest.expectedErrno, errno)
+			}
+			if child != nil && getNodeType(child) != curTest.expectedType {
+				t.Errorf("Expected a %s, got a %s", curTest.expectedType, getNodeType(child))
+			}
+			exitStatus := make([]byte, 64)
+			size, xattrErrno := r.Getxattr(context.Background(), exitStatusXattr, exitStatus)
+			if xattrErrno != 0 || string(exitStatus[:size]) != curTest.expectedExitStatus {
+				t.Errorf("Expected the exit status '%s' to be recorded, got '%s', %v", curTest.expectedExitStatus, exitStatus[:size], xattrErrno)
+			}
+		})
+	}
+}
+
+func TestTypeCommand(t *testing.T) {
+	typeScript := writeTestScript(t, `case "$FUSEE_NAME" in
+dir*) echo dir;;
+file*) echo file;;
+link) echo symlink;;
+failing) exit 1;;
+*) echo garbage;;
+esac
+`)
+	r := newTestRoot(t, config.Mount{
+		Path:          t.TempDir(),
+		ReadCommand:   "printf 'dir1\\nfile1\\ndir2\\nlink\\nfailing\\nother\\n'",
+		NameSeparator: "\n",
+		Mode:          0o555,
+		ThreadCount:   2,
+		Cache:         true,
+		CacheSeconds:  60,
+		Directory:     config.Directory{Mode: 0o555, ReadCommand: "true", TypeCommand: "sh " + typeScript},
+		File:          config.File{Mode: 0o444, ReadCommand: "true"},
+	})
+	children := map[string]string{}
+	for curName, curChild := range r.Children() {
+		children[curName] = getNodeType(curChild)
+	}
+	expected := map[string]string{"dir1": entryTypeDir, "dir2": entryTypeDir, "file1": entryTypeFile}
+	if !reflect.DeepEqual(children, expected) {
+		t.Errorf("Expected the children %v, got %v", expected, children)
+	}
+}
+
+// TestTypeCommandTimeout checks that the typeCommands run for the entries of a mount's root are
+// limited by the mount's timeout.
+func TestTypeCommandTimeout(t *testing.T) {
+	r := newTestRoot(t, config.Mount{
+		Path:           t.TempDir(),
+		ReadCommand:    "printf 'fast\\nslow\\n'",
+		NameSeparator:  "\n",
+		Mode:           0o555,
+		ThreadCount:    2,
+		TimeoutSeconds: 0.5,
+		Directory:      config.Directory{Mode: 0o555, ReadCommand: "true", TypeCommand: `[ "$FUSEE_NAME" = slow ] && sleep 5; echo file`},
+		File:           config.File{Mode: 0o444, ReadCommand: "true"},
+	})
+	children := map[string]string{}
+	for curName, curChild := range r.Children() {
+		children[curName] = getNodeType(curChild)
+	}
+	expected := map[string]string{"fast": entryTypeFile}
+	if !reflect.DeepEqual(children, expected) {
+		t.Errorf("Expected the children %v, got %v", expected, children)
+	}
+}
+
+func TestParseEntryType(t *testing.T) {
+	tests := []struct {
+		output    string
+		expected  string
+		expectErr bool
+	}{
+		{"dir\n", entryTypeDir, false},
+		{" file ", entryTypeFile, false},
+		{"symlink", "", true},
+		{"", "", true},
+		{"directory", "", true},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.output, func(t *testing.T) {
+			entryType, parseErr := parseEntryType([]byte(curTest.output))
+			if (parseErr != nil) != curTest.expectErr {
+				t.Fatalf("Expected an error: %t, got '%v'", curTest.expectErr, parseErr)
+			}
+			if entryType != curTest.expected {
+				t.Errorf("Expected '%s', got '%s'", curTest.expected, entryType)
+			}
+		})
+	}
+}
+
+// BenchmarkProbeEntries lists entries that are each probed with a slow probe command, comparing
+// probing them one at a time with probing them in parallel through the command pool.
+func BenchmarkProbeEntries(b *testing.B) {
+	const noEntries = 32
+	for _, curThreadCount := range []uint{1, 4, 16} {
+		b.Run(fmt.Sprintf("threads=%d", curThreadCount), func(b *testing.B) {
+			conf := config.Mount{
+				Path:          b.TempDir(),
+				ReadCommand:   fmt.Sprintf("seq 1 %d", noEntries),
+				NameSeparator: "\n",
+				Mode:          0o555,
+				ThreadCount:   curThreadCount,
+				// Fails after a delay, so that every entry is added as a file
+				Directory: config.Directory{Mode: 0o555, ReadCommand: "sleep 0.01; exit 1"},
+				File:      config.File{Mode: 0o444, ReadCommand: "true"},
+			}
+			for i := 0; i < b.N; i++ {
+				r, rootErr := NewRoot("bench", conf)
+				if rootErr != nil {
+					b.Fatal(rootErr)
+				}
+				fs.NewNodeFS(r, &fs.Options{})
+				if noChildren := len(r.Children()); noChildren != noEntries {
+					b.Fatalf("Expected %d entries, got %d", noEntries, noChildren)
+				}
+				r.StopCommands(0)
+			}
+		})
+	}
+}
@@ -0,0 +1,444 @@
+package supervisor
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/jasonrogena/fusee/internal/app/fusee/config"
+	"github.com/jasonrogena/fusee/internal/app/fusee/mount"
+	"github.com/jasonrogena/fusee/internal/pkg/command"
+	log "github.com/sirupsen/logrus"
+)
+
+// mounter is a mount the supervisor mounts and keeps track of.
+type mounter interface {
+	Mount(debug bool) (server, error)
+	controller
+}
+
+// controller contains the methods of a mount used once it has been created.
+type controller interface {
+	Unmount(deadline time.Time) error
+	StopCommands(gracePeriod time.Duration) int
+	Reconfigure(conf config.Mount) error
+	GetName() string
+	GetTree() []mount.NodeStatus
+	Invalidate(path string) error
+	GetCommandStats() []command.RunnerStats
+}
+
+// server handles the FUSE requests of a mounted mount. Wait blocks until the mount ends.
+type server interface {
+	Wait()
+}
+
+// rootMounter is a mounter for the root of a mount created using mount.NewRoot.
+type rootMounter struct {
+	controller
+	root interface {
+		Mount(debug bool) (*fuse.Server, error)
+	}
+}
+
+func newRootMounter(name string, conf config.Mount) (mounter, error) {
+	root, rootErr := mount.NewRoot(name, conf)
+	if rootErr != nil {
+		return nil, rootErr
+	}
+
+	return &rootMounter{controller: root, root: root}, nil
+}
+
+func (m *rootMounter) Mount(debug bool) (server, error) {
+	fuseServer, mountErr := m.root.Mount(debug)
+	if mountErr != nil {
+		// Don't return a nil *fuse.Server as a non-nil server
+		return nil, mountErr
+	}
+
+	return fuseServer, nil
+}
+
+type mountState struct {
+	root   mounter
+	config config.Mount
+	server server
+	err    error
+	// Set if the mount could not be unmounted when fusee was shutting down
+	unmountErr error
+	ended      bool
+	// Closed once the mount has been mounted, or has failed to mount
+	mountDone chan struct{}
+}
+
+// MountStatus describes the state of one of the supervisor's mounts.
+type MountStatus struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Mounted bool   `json:"mounted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Supervisor mounts all the mounts defined in a config in parallel and keeps track of the
+// FUSE server handling each of them.
+type Supervisor struct {
+	config      config.Config
+	configMutex *sync.RWMutex
+	debug       bool
+	mounts      map[string]*mountState
+	mountsMutex *sync.Mutex
+	wg          sync.WaitGroup
+	// Set once Shutdown is called. Mounts that finish mounting afterwards are unmounted right away
+	shuttingDown bool
+	// Creates the mounts. Defaults to creating them using mount.NewRoot
+	newMounter func(name string, conf config.Mount) (mounter, error)
+}
+
+func NewSupervisor(conf config.Config, debug bool) *Supervisor {
+	return &Supervisor{
+		config:      conf,
+		configMutex: new(sync.RWMutex),
+		debug:       debug,
+		mounts:      map[string]*mountState{},
+		mountsMutex: new(sync.Mutex),
+		newMounter:  newRootMounter,
+	}
+}
+
+// Start mounts every configured mount in its own goroutine. A mount failing does not affect
+// the other mounts.
+func (s *Supervisor) Start() {
+	for curMountName, curMountConf := range s.getConfig().Mounts {
+		s.startMount(curMountName, curMountConf)
+	}
+}
+
+func (s *Supervisor) startMount(name string, conf config.Mount) {
+	state := &mountState{config: conf, mountDone: make(chan struct{})}
+	var root mounter
+	rootErr := config.ValidateMountOptions(conf)
+	if rootErr != nil {
+		rootErr = fmt.Errorf("Not mounting '%s' since its mount options are invalid: %w", name, rootErr)
+	} else {
+		root, rootErr = s.newMounter(name, conf)
+	}
+	if rootErr != nil {
+		log.Error(rootErr.Error())
+		state.err = rootErr
+	} else {
+		state.root = root
+	}
+	s.mountsMutex.Lock()
+	s.mounts[name] = state
+	s.mountsMutex.Unlock()
+	if rootErr != nil {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		server, mountErr := state.root.Mount(s.debug)
+		if mountErr != nil {
+			log.Error(fmt.Sprintf("Unable to mount '%s' on '%s': %v", name, conf.Path, mountErr))
+			s.setMountState(state, nil, mountErr)
+			close(state.mountDone)
+			return
+		}
+		shuttingDown := s.setMountState(state, server, nil)
+		close(state.mountDone)
+		if shuttingDown {
+			log.Info(fmt.Sprintf("Unmounting '%s' since fusee is shutting down", name))
+			if unmountErr := state.root.Unmount(time.Now().Add(s.gracePeriod())); unmountErr != nil {
+				log.Error(unmountErr.Error())
+				state.root.StopCommands(0)
+				s.setUnmountErr(state, unmountErr)
+				return
+			}
+		} else {
+			log.Info(fmt.Sprintf("Mounted '%s' on '%s'", name, conf.Path))
+		}
+		server.Wait()
+		log.Info(fmt.Sprintf("Mount '%s' on '%s' has ended", name, conf.Path))
+		s.mountsMutex.Lock()
+		state.ended = true
+		s.mountsMutex.Unlock()
+		noKilled := state.root.StopCommands(s.gracePeriod())
+		if noKilled > 0 {
+			log.Warn(fmt.Sprintf("Killed %d commands that were still running for '%s'", noKilled, name))
+		}
+	}()
+}
+
+// setMountState sets the server and error of the mount. Returns true if the supervisor is shutting
+// down.
+func (s *Supervisor) setMountState(state *mountState, mountServer server, err error) bool {
+	s.mountsMutex.Lock()
+	defer s.mountsMutex.Unlock()
+	state.server = mountServer
+	state.err = err
+	return s.shuttingDown
+}
+
+func (s *Supervisor) setUnmountErr(state *mountState, err error) {
+	s.mountsMutex.Lock()
+	defer s.mountsMutex.Unlock()
+	state.unmountErr = err
+}
+
+// Wait blocks until every mount has ended, or could not be unmounted when shutting down. It returns
+// the errors, keyed by mount name, for the mounts that could not be mounted and, separately, for
+// the mounts that could not be unmounted.
+func (s *Supervisor) Wait() (map[string]error, map[string]error) {
+	s.wg.Wait()
+	s.mountsMutex.Lock()
+	defer s.mountsMutex.Unlock()
+	mountErrs := map[string]error{}
+	unmountErrs := map[string]error{}
+	for curName, curState := range s.mounts {
+		if curState.err != nil {
+			mountErrs[curName] = curState.err
+		}
+		if curState.unmountErr != nil {
+			unmountErrs[curName] = curState.unmountErr
+		}
+	}
+
+	return mountErrs, unmountErrs
+}
+
+func (s *Supervisor) getConfig() config.Config {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+	return s.config
+}
+
+func (s *Supervisor) gracePeriod() time.Duration {
+	return time.Duration(s.getConfig().ShutdownGraceSeconds) * time.Second
+}
+
+// Shutdown unmounts all the mounts in parallel, retrying for up to the configured shutdown grace
+// period if a mount is busy. Once a mount is unmounted, its running commands are given the grace
+// period to finish before they are killed. Call Wait to block until this is done. If a mount
+// cannot be unmounted, its running commands are killed and an error is returned. Mounts that are
+// still being mounted are unmounted as soon as they are mounted.
+func (s *Supervisor) Shutdown() error {
+	deadline := time.Now().Add(s.gracePeriod())
+	s.mountsMutex.Lock()
+	s.shuttingDown = true
+	states := []*mountState{}
+	for _, curState := range s.mounts {
+		if curState.server != nil {
+			states = append(states, curState)
+		}
+	}
+	s.mountsMutex.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(states))
+	for curIndex, curState := range states {
+		wg.Add(1)
+		go func(index int, state *mountState) {
+			defer wg.Done()
+			log.Info(fmt.Sprintf("Unmounting '%s'", state.root.GetName()))
+			errs[index] = state.root.Unmount(deadline)
+			if errs[index] != nil {
+				// The mount will outlive fusee. Don't leave its commands running
+				state.root.StopCommands(0)
+				s.setUnmountErr(state, errs[index])
+			}
+		}(curIndex, curState)
+	}
+	wg.Wait()
+
+	errMessages := []string{}
+	for _, curErr := range errs {
+		if curErr != nil {
+			errMessages = append(errMessages, curErr.Error())
+		}
+	}
+	if len(errMessages) > 0 {
+		return errors.New(strings.Join(errMessages, "; "))
+	}
+
+	return nil
+}
+
+// Reload applies a new configuration to the running mounts. Mounts not in the running
+// configuration are mounted and mounts missing from the new configuration are unmounted. Mounts
+// whose configuration has changed are reconfigured in place, unless their path, thread count or
+// mount options have changed, in which case they are remounted. A mount is not remounted if its new
+// mount options are invalid.
+func (s *Supervisor) Reload(newConf config.Config) error {
+	s.mountsMutex.Lock()
+	if s.shuttingDown {
+		s.mountsMutex.Unlock()
+		return errors.New("Not reloading the configuration since fusee is shutting down")
+	}
+	// Keeps Wait from returning while a remounted mount is unmounted and not yet mounted again.
+	// Added while holding the lock, and before shutting down, so that it is never added once Wait
+	// could have returned
+	s.wg.Add(1)
+	defer s.wg.Done()
+	curMounts := map[string]*mountState{}
+	curConfigs := map[string]config.Mount{}
+	isMounted := map[string]bool{}
+	isMounting := map[string]bool{}
+	for curName, curState := range s.mounts {
+		curMounts[curName] = curState
+		curConfigs[curName] = curState.config
+		isMounted[curName] = curState.server != nil
+		isMounting[curName] = curState.root != nil && curState.server == nil && curState.err == nil
+	}
+	s.mountsMutex.Unlock()
+
+	s.configMutex.Lock()
+	s.config = newConf
+	s.configMutex.Unlock()
+	deadline := time.Now().Add(s.gracePeriod())
+
+	errMessages := []string{}
+	for curName, curState := range curMounts {
+		curConfig := curConfigs[curName]
+		newMountConf, inNewConf := newConf.Mounts[curName]
+		if inNewConf && reflect.DeepEqual(curConfig, newMountConf) {
+			continue
+		}
+		if inNewConf && (isMounted[curName] || isMounting[curName]) &&
+			curConfig.HasSameMountOptions(newMountConf) &&
+			curConfig.ThreadCount == newMountConf.ThreadCount {
+			if reconfigureErr := curState.root.Reconfigure(newMountConf); reconfigureErr != nil {
+				errMessages = append(errMessages, reconfigureErr.Error())
+				continue
+			}
+			s.mountsMutex.Lock()
+			curState.config = newMountConf
+			s.mountsMutex.Unlock()
+			continue
+		}
+		if isMounting[curName] {
+			// The mount can only be unmounted, and replaced, once it has been mounted
+			log.Info(fmt.Sprintf("Waiting for '%s' to be mounted before applying its new configuration", curName))
+			<-curState.mountDone
+			s.mountsMutex.Lock()
+			shuttingDown := s.shuttingDown
+			isMounted[curName] = curState.server != nil
+			s.mountsMutex.Unlock()
+			if shuttingDown {
+				errMessages = append(errMessages, fmt.Sprintf("Not reloading '%s' since fusee is shutting down", curName))
+				continue
+			}
+		}
+		if optionsErr := config.ValidateMountOptions(newMountConf); inNewConf && isMounted[curName] && optionsErr != nil {
+			// Keep the live mount instead of replacing it with one that cannot be mounted
+			errMessages = append(errMessages, fmt.Sprintf("Not remounting '%s' since its mount options are invalid: %v", curName, optionsErr))
+			continue
+		}
+
+		if isMounted[curName] {
+			log.Info(fmt.Sprintf("Unmounting '%s'", curName))
+			unmountErr := curState.root.Unmount(deadline)
+			if unmountErr != nil {
+				errMessages = append(errMessages, unmountErr.Error())
+				continue
+			}
+		}
+		s.mountsMutex.Lock()
+		delete(s.mounts, curName)
+		s.mountsMutex.Unlock()
+		if inNewConf {
+			s.startMount(curName, newMountConf)
+		}
+	}
+	for curName, curMountConf := range newConf.Mounts {
+		if _, isRunning := curMounts[curName]; !isRunning {
+			s.startMount(curName, curMountConf)
+		}
+	}
+
+	if len(errMessages) > 0 {
+		return errors.New(strings.Join(errMessages, "; "))
+	}
+
+	return nil
+}
+
+// GetMounts returns the status of each of the supervisor's mounts.
+func (s *Supervisor) GetMounts() []MountStatus {
+	s.mountsMutex.Lock()
+	defer s.mountsMutex.Unlock()
+	names := []string{}
+	for curName := range s.mounts {
+		names = append(names, curName)
+	}
+	sort.Strings(names)
+
+	statuses := []MountStatus{}
+	for _, curName := range names {
+		curState := s.mounts[curName]
+		status := MountStatus{
+			Name:    curName,
+			Path:    curState.config.Path,
+			Mounted: curState.server != nil && !curState.ended,
+		}
+		if curState.err != nil {
+			status.Error = curState.err.Error()
+		} else if curState.unmountErr != nil {
+			status.Error = curState.unmountErr.Error()
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+func (s *Supervisor) getMountedRoot(name string) (mounter, error) {
+	s.mountsMutex.Lock()
+	defer s.mountsMutex.Unlock()
+	state, found := s.mounts[name]
+	if !found {
+		return nil, fmt.Errorf("Mount '%s' does not exist", name)
+	}
+	if state.server == nil || state.ended {
+		return nil, fmt.Errorf("Mount '%s' is not mounted", name)
+	}
+
+	return state.root, nil
+}
+
+// GetCommandStats returns the stats for each of the command runners of a mount.
+func (s *Supervisor) GetCommandStats(name string) ([]command.RunnerStats, error) {
+	root, rootErr := s.getMountedRoot(name)
+	if rootErr != nil {
+		return nil, rootErr
+	}
+
+	return root.GetCommandStats(), nil
+}
+
+// GetTree returns the status of each of the nodes loaded in a mount.
+func (s *Supervisor) GetTree(name string) ([]mount.NodeStatus, error) {
+	root, rootErr := s.getMountedRoot(name)
+	if rootErr != nil {
+		return nil, rootErr
+	}
+
+	return root.GetTree(), nil
+}
+
+// Invalidate invalidates the cached content of the node at path in a mount, and all the node's
+// descendants. If path is blank, the whole mount is invalidated.
+func (s *Supervisor) Invalidate(name string, path string) error {
+	root, rootErr := s.getMountedRoot(name)
+	if rootErr != nil {
+		return rootErr
+	}
+
+	return root.Invalidate(path)
+}
@@ -0,0 +1,330 @@
+package supervisor
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jasonrogena/fusee/internal/app/fusee/config"
+	"github.com/jasonrogena/fusee/internal/app/fusee/mount"
+	"github.com/jasonrogena/fusee/internal/pkg/command"
+)
+
+// fakeMounter is a mount that is mounted until it is unmounted. Mount blocks until mountGate is
+// closed, if it is set. Unmount fails with unmountErr, if it is set.
+type fakeMounter struct {
+	name       string
+	mountGate  chan struct{}
+	ended      chan struct{}
+	endedOnce  *sync.Once
+	unmounted  chan struct{}
+	unmountErr error
+}
+
+func newFakeMounter(name string, mountGate chan struct{}) *fakeMounter {
+	return &fakeMounter{
+		name:      name,
+		mountGate: mountGate,
+		ended:     make(chan struct{}),
+		endedOnce: new(sync.Once),
+		unmounted: make(chan struct{}),
+	}
+}
+
+func (m *fakeMounter) Mount(debug bool) (server, error) {
+	if m.mountGate != nil {
+		<-m.mountGate
+	}
+	return m, nil
+}
+
+func (m *fakeMounter) Wait() {
+	<-m.ended
+}
+
+// Unmount ends the mount. Like go-fuse, it returns a little after the server has ended, giving
+// the goroutine waiting on the server time to act on the mount ending.
+func (m *fakeMounter) Unmount(deadline time.Time) error {
+	if m.unmountErr != nil {
+		return m.unmountErr
+	}
+	m.endedOnce.Do(func() {
+		close(m.unmounted)
+		close(m.ended)
+		time.Sleep(20 * time.Millisecond)
+	})
+	return nil
+}
+
+func (m *fakeMounter) StopCommands(gracePeriod time.Duration) int {
+	return 0
+}
+
+func (m *fakeMounter) Reconfigure(conf config.Mount) error {
+	return nil
+}
+
+func (m *fakeMounter) GetName() string {
+	return m.name
+}
+
+func (m *fakeMounter) GetTree() []mount.NodeStatus {
+	return nil
+}
+
+func (m *fakeMounter) Invalidate(path string) error {
+	return nil
+}
+
+func (m *fakeMounter) GetCommandStats() []command.RunnerStats {
+	return nil
+}
+
+// newFakeSupervisor returns a supervisor that creates fake mounts, and a function returning the
+// mounts it created.
+func newFakeSupervisor(conf config.Config, mountGate chan struct{}) (*Supervisor, func() []*fakeMounter) {
+	s := NewSupervisor(conf, false)
+	created := []*fakeMounter{}
+	mutex := new(sync.Mutex)
+	s.newMounter = func(name string, conf config.Mount) (mounter, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		m := newFakeMounter(name, mountGate)
+		created = append(created, m)
+		return m, nil
+	}
+
+	return s, func() []*fakeMounter {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]*fakeMounter{}, created...)
+	}
+}
+
+func waitUntilMounted(t *testing.T, s *Supervisor, name string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, curStatus := range s.GetMounts() {
+			if curStatus.Name == name && curStatus.Mounted {
+				return
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Mount '%s' was not mounted", name)
+}
+
+func waitForChannel(t *testing.T, ch chan struct{}, description string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %s", description)
+	}
+}
+
+func TestReloadRemount(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(conf *config.Mount)
+	}{
+		{"path", func(conf *config.Mount) { conf.Path = "/tmp/other" }},
+		{"threadCount", func(conf *config.Mount) { conf.ThreadCount = 2 }},
+		{"mount option", func(conf *config.Mount) { conf.AttrTimeoutSeconds = 1 }},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			mountConf := config.Mount{Path: "/tmp/t", ThreadCount: 1}
+			s, getCreated := newFakeSupervisor(config.Config{Mounts: map[string]config.Mount{"t": mountConf}}, nil)
+			s.Start()
+			waitUntilMounted(t, s, "t")
+			waited := make(chan struct{})
+			go func() {
+				s.Wait()
+				close(waited)
+			}()
+
+			newMountConf := mountConf
+			curTest.change(&newMountConf)
+			if reloadErr := s.Reload(config.Config{Mounts: map[string]config.Mount{"t": newMountConf}}); reloadErr != nil {
+				t.Fatalf("Reload failed: %v", reloadErr)
+			}
+			waitUntilMounted(t, s, "t")
+			select {
+			case <-waited:
+				t.Fatal("Wait returned while the mount was being remounted")
+			default:
+			}
+			created := getCreated()
+			if len(created) != 2 {
+				t.Fatalf("Expected the mount to be created twice, was created %d times", len(created))
+			}
+			waitForChannel(t, created[0].unmounted, "the previous mount to be unmounted")
+			if statuses := s.GetMounts(); statuses[0].Path != newMountConf.Path {
+				t.Errorf("Expected the mount's path to be '%s', got '%s'", newMountConf.Path, statuses[0].Path)
+			}
+
+			if shutdownErr := s.Shutdown(); shutdownErr != nil {
+				t.Fatalf("Shutdown failed: %v", shutdownErr)
+			}
+			waitForChannel(t, waited, "Wait to return")
+		})
+	}
+}
+
+func TestReloadReconfigure(t *testing.T) {
+	mountConf := config.Mount{Path: "/tmp/t", ThreadCount: 1}
+	s, getCreated := newFakeSupervisor(config.Config{Mounts: map[string]config.Mount{"t": mountConf}}, nil)
+	s.Start()
+	waitUntilMounted(t, s, "t")
+
+	newMountConf := mountConf
+	newMountConf.CacheSeconds = 10
+	if reloadErr := s.Reload(config.Config{Mounts: map[string]config.Mount{"t": newMountConf}}); reloadErr != nil {
+		t.Fatalf("Reload failed: %v", reloadErr)
+	}
+	if created := getCreated(); len(created) != 1 {
+		t.Fatalf("Expected the mount to be reconfigured in place, was created %d times", len(created))
+	}
+
+	s.Shutdown()
+	s.Wait()
+}
+
+// TestReloadWhileMounting checks that a mount whose configuration changes while it is being
+// mounted is unmounted once it is mounted, and not left behind keeping Wait from returning.
+func TestReloadWhileMounting(t *testing.T) {
+	tests := []struct {
+		name            string
+		change          func(conf *config.Mount)
+		expectedCreated int
+	}{
+		{"remount", func(conf *config.Mount) { conf.Path = "/tmp/other" }, 2},
+		{"reconfigure", func(conf *config.Mount) { conf.CacheSeconds = 10 }, 1},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			mountGate := make(chan struct{})
+			mountConf := config.Mount{Path: "/tmp/t", ThreadCount: 1}
+			s, getCreated := newFakeSupervisor(config.Config{Mounts: map[string]config.Mount{"t": mountConf}}, mountGate)
+			s.Start()
+
+			newMountConf := mountConf
+			curTest.change(&newMountConf)
+			reloaded := make(chan struct{})
+			var reloadErr error
+			go func() {
+				reloadErr = s.Reload(config.Config{Mounts: map[string]config.Mount{"t": newMountConf}})
+				close(reloaded)
+			}()
+			time.Sleep(50 * time.Millisecond)
+			close(mountGate)
+			waitForChannel(t, reloaded, "Reload to return")
+			if reloadErr != nil {
+				t.Fatalf("Reload failed: %v", reloadErr)
+			}
+			waitUntilMounted(t, s, "t")
+			created := getCreated()
+			if len(created) != curTest.expectedCreated {
+				t.Fatalf("Expected the mount to be created %d times, was created %d times", curTest.expectedCreated, len(created))
+			}
+			if curTest.expectedCreated > 1 {
+				waitForChannel(t, created[0].unmounted, "the previous mount to be unmounted")
+			}
+			if statuses := s.GetMounts(); statuses[0].Path != newMountConf.Path {
+				t.Errorf("Expected the mount's path to be '%s', got '%s'", newMountConf.Path, statuses[0].Path)
+			}
+
+			if shutdownErr := s.Shutdown(); shutdownErr != nil {
+				t.Fatalf("Shutdown failed: %v", shutdownErr)
+			}
+			waited := make(chan struct{})
+			go func() {
+				s.Wait()
+				close(waited)
+			}()
+			waitForChannel(t, waited, "Wait to return")
+		})
+	}
+}
+
+func TestShutdownWhileMounting(t *testing.T) {
+	mountGate := make(chan struct{})
+	s, getCreated := newFakeSupervisor(config.Config{Mounts: map[string]config.Mount{"t": {Path: "/tmp/t"}}}, mountGate)
+	s.Start()
+	if shutdownErr := s.Shutdown(); shutdownErr != nil {
+		t.Fatalf("Shutdown failed: %v", shutdownErr)
+	}
+	close(mountGate)
+
+	waitForChannel(t, getCreated()[0].unmounted, "the mount to be unmounted")
+	s.Wait()
+	if reloadErr := s.Reload(config.Config{Mounts: map[string]config.Mount{"u": {Path: "/tmp/u"}}}); reloadErr == nil {
+		t.Error("Expected reloading after shutting down to fail")
+	}
+}
+
+// TestUnmountErrorWhileMounting checks that a mount that cannot be unmounted once it is mounted,
+// after fusee started shutting down, is reported as an unmount error and not a mount error.
+func TestUnmountErrorWhileMounting(t *testing.T) {
+	mountGate := make(chan struct{})
+	s, getCreated := newFakeSupervisor(config.Config{Mounts: map[string]config.Mount{"t": {Path: "/tmp/t"}}}, mountGate)
+	s.Start()
+	getCreated()[0].unmountErr = errors.New("device is busy")
+	if shutdownErr := s.Shutdown(); shutdownErr != nil {
+		t.Fatalf("Shutdown failed: %v", shutdownErr)
+	}
+	close(mountGate)
+
+	mountErrs, unmountErrs := s.Wait()
+	if len(mountErrs) != 0 {
+		t.Errorf("Expected no mount errors, got %v", mountErrs)
+	}
+	if unmountErrs["t"] == nil || unmountErrs["t"].Error() != "device is busy" {
+		t.Errorf("Expected the unmount error of 't', got %v", unmountErrs)
+	}
+}
+
+func TestInvalidMountOptions(t *testing.T) {
+	mounts := map[string]config.Mount{
+		"valid":   {Path: "/tmp/valid"},
+		"invalid": {Path: "/tmp/invalid", MaxRead: -1},
+	}
+	s, getCreated := newFakeSupervisor(config.Config{Mounts: mounts}, nil)
+	s.Start()
+	waitUntilMounted(t, s, "valid")
+	if created := getCreated(); len(created) != 1 || created[0].name != "valid" {
+		t.Fatalf("Expected only the valid mount to be created, got %d mounts", len(created))
+	}
+
+	// The live mount is kept if the reloaded configuration has invalid mount options
+	reloadedMounts := map[string]config.Mount{
+		"valid":   {Path: "/tmp/valid", EntryTimeoutSeconds: -1},
+		"invalid": mounts["invalid"],
+	}
+	reloadErr := s.Reload(config.Config{Mounts: reloadedMounts})
+	if reloadErr == nil || !strings.Contains(reloadErr.Error(), "entryTimeoutSeconds cannot be negative") {
+		t.Errorf("Expected the reload to fail because of the mount options, got '%v'", reloadErr)
+	}
+	created := getCreated()
+	if len(created) != 1 {
+		t.Fatalf("Expected the mount with invalid options not to be created, got %d mounts", len(created))
+	}
+	select {
+	case <-created[0].unmounted:
+		t.Error("Expected the live mount to be kept")
+	default:
+	}
+
+	s.Shutdown()
+	errs, _ := s.Wait()
+	if errs["invalid"] == nil || !strings.Contains(errs["invalid"].Error(), "maxRead should be between") {
+		t.Errorf("Expected 'invalid' to fail because of its mount options, got '%v'", errs["invalid"])
+	}
+	if errs["valid"] != nil {
+		t.Errorf("Expected 'valid' to be mounted, got '%v'", errs["valid"])
+	}
+}
@@ -0,0 +1,174 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SyslogTarget is the target to pass to Open to have records sent to syslog.
+const SyslogTarget = "syslog"
+
+// The text redacted parts of commands are replaced with
+const redactedText = "[REDACTED]"
+
+// The types of records written to the audit log
+const (
+	// TypeExecution records a command being run
+	TypeExecution = "execution"
+	// TypeDenial records a caller being denied access by an access policy
+	TypeDenial = "denial"
+)
+
+// Execution records a command being run. The output of the command is never recorded.
+type Execution struct {
+	Type      string `json:"type"`
+	Time      string `json:"time"`
+	Mount     string `json:"mount"`
+	Path      string `json:"path"`
+	Kind      string `json:"kind"`
+	Command   string `json:"command"`
+	CallerUID uint32 `json:"callerUid"`
+	CallerPID uint32 `json:"callerPid"`
+	// Is null if the command did not exit normally, for instance because it timed out
+	ExitCode        *int    `json:"exitCode"`
+	ExitStatus      string  `json:"exitStatus"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	OutputBytes     int     `json:"outputBytes"`
+}
+
+// Denial records a caller being denied access by an access policy.
+type Denial struct {
+	Type      string `json:"type"`
+	Time      string `json:"time"`
+	Mount     string `json:"mount"`
+	Path      string `json:"path"`
+	Operation string `json:"operation"`
+	CallerUID uint32 `json:"callerUid"`
+	CallerGID uint32 `json:"callerGid"`
+	CallerPID uint32 `json:"callerPid"`
+	CallerExe string `json:"callerExe"`
+	Reason    string `json:"reason"`
+}
+
+// Log writes audit records, one JSON object per line, to a file or syslog.
+type Log struct {
+	writer         io.WriteCloser
+	redactPatterns []*regexp.Regexp
+	mutex          *sync.Mutex
+}
+
+var defaultLog *Log
+var defaultLogMutex = new(sync.Mutex)
+
+// Open opens the audit log at target, which is either the path of a file records are appended to
+// or SyslogTarget. Matches of the redactPatterns regular expressions in the commands recorded are
+// replaced with "[REDACTED]".
+func Open(target string, redactPatterns []string) (*Log, error) {
+	compiledPatterns := []*regexp.Regexp{}
+	for _, curPattern := range redactPatterns {
+		compiledPattern, compileErr := regexp.Compile(curPattern)
+		if compileErr != nil {
+			return nil, fmt.Errorf("Invalid redact pattern '%s': %w", curPattern, compileErr)
+		}
+		compiledPatterns = append(compiledPatterns, compiledPattern)
+	}
+
+	var writer io.WriteCloser
+	if target == SyslogTarget {
+		syslogWriter, syslogErr := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, "fusee")
+		if syslogErr != nil {
+			return nil, syslogErr
+		}
+		writer = syslogWriter
+	} else {
+		file, openErr := os.OpenFile(target, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if openErr != nil {
+			return nil, openErr
+		}
+		writer = file
+	}
+
+	return &Log{
+		writer:         writer,
+		redactPatterns: compiledPatterns,
+		mutex:          new(sync.Mutex),
+	}, nil
+}
+
+// Close closes the audit log.
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.writer.Close()
+}
+
+func (l *Log) write(record interface{}) {
+	line, marshalErr := json.Marshal(record)
+	if marshalErr != nil {
+		log.Error(fmt.Sprintf("Unable to write audit record: %v", marshalErr))
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, writeErr := l.writer.Write(append(line, '\n')); writeErr != nil {
+		log.Error(fmt.Sprintf("Unable to write audit record: %v", writeErr))
+	}
+}
+
+func (l *Log) redact(command string) string {
+	for _, curPattern := range l.redactPatterns {
+		command = curPattern.ReplaceAllString(command, redactedText)
+	}
+
+	return command
+}
+
+// SetDefault sets the log RecordExecution and RecordDenial write to. Records are not written
+// anywhere if the default log is nil.
+func SetDefault(l *Log) {
+	defaultLogMutex.Lock()
+	defer defaultLogMutex.Unlock()
+	defaultLog = l
+}
+
+func getDefault() *Log {
+	defaultLogMutex.Lock()
+	defer defaultLogMutex.Unlock()
+	return defaultLog
+}
+
+// IsEnabled returns true if a default log is set.
+func IsEnabled() bool {
+	return getDefault() != nil
+}
+
+// RecordExecution writes the execution to the default log, with the command redacted.
+func RecordExecution(execution Execution) {
+	l := getDefault()
+	if l == nil {
+		return
+	}
+	execution.Type = TypeExecution
+	execution.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	execution.Command = l.redact(execution.Command)
+	l.write(execution)
+}
+
+// RecordDenial writes the denial to the default log.
+func RecordDenial(denial Denial) {
+	l := getDefault()
+	if l == nil {
+		return
+	}
+	denial.Type = TypeDenial
+	denial.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	l.write(denial)
+}
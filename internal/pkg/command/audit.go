@@ -0,0 +1,47 @@
+package command
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jasonrogena/fusee/internal/pkg/audit"
+)
+
+// recordExecution writes a record of the command having been run with args to the audit log.
+func recordExecution(c *Command, args []string, startTime time.Time, outputBytes int, runErr error) {
+	if !audit.IsEnabled() {
+		return
+	}
+	execution := audit.Execution{
+		Mount:           c.state.MountName,
+		Path:            c.state.RelativePath,
+		Kind:            c.kind,
+		Command:         formatArgs(c, args),
+		CallerUID:       c.state.CallerUID,
+		CallerPID:       c.state.CallerPID,
+		ExitStatus:      GetExitStatus(runErr),
+		DurationSeconds: time.Now().Sub(startTime).Seconds(),
+		OutputBytes:     outputBytes,
+	}
+	if exitCode, exited := GetExitCode(runErr); exited {
+		execution.ExitCode = &exitCode
+	}
+	audit.RecordExecution(execution)
+}
+
+// formatArgs returns the command as it would be typed in a shell.
+func formatArgs(c *Command, args []string) string {
+	if c.template.Backend != nil {
+		return c.template.Backend.Describe(c.kind, c.state)
+	}
+	if len(c.template.Args) == 0 && len(args) == 3 {
+		// The command was run using sh -c
+		return args[2]
+	}
+	quotedArgs := []string{}
+	for _, curArg := range args {
+		quotedArgs = append(quotedArgs, shellQuote(curArg))
+	}
+
+	return strings.Join(quotedArgs, " ")
+}
@@ -2,16 +2,142 @@ package command
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
 	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"text/template"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// The kinds of commands run by fusee.
+const (
+	// KindList lists the entries in a directory
+	KindList = "list"
+	// KindProbe tests whether an entry is a directory
+	KindProbe = "probe"
+	// KindRead reads the contents of a file
+	KindRead = "read"
+	// KindType prints whether an entry is a directory or a file
+	KindType = "type"
+	// KindLookup prints the type of an entry, or fails if the entry does not exist
+	KindLookup = "lookup"
+)
+
+// ErrTimedOut is returned when a command takes longer than its timeout to run.
+var ErrTimedOut = errors.New("Command timed out")
+
+// ErrInterrupted is returned when the context a command is run in is canceled, for instance
+// because the FUSE operation the command is run for is interrupted.
+var ErrInterrupted = errors.New("Command interrupted")
+
+// Template is the command to run, before it is filled in using a State. Either Shell, Args or
+// Backend should be set.
+type Template struct {
+	// A template for a command that is run using sh -c
+	Shell string
+	// Templates for the arguments of a command that is run directly, without a shell. Each argument
+	// is filled in separately, so filenames in the arguments do not need to be quoted
+	Args []string
+	// Environment variables to set for the command, in addition to the FUSEE_* variables
+	Env map[string]string
+	// The directory to run the command in. If blank, the command is run in fusee's working directory
+	WorkingDir string
+	// Whether to run the command without inheriting fusee's environment variables
+	CleanEnv bool
+	// If set, the command is sent to the backend instead of being run as a process
+	Backend Backend
+	// Whether the output of the command depends on the caller even though the template does not
+	// use any caller variable, for instance because a script it runs reads the FUSEE_CALLER_*
+	// variables. Output is then keyed on the caller's user and group IDs
+	PerCaller bool
+	// Set by Parse
+	parsedShell *template.Template
+	parsedArgs  []*template.Template
+}
+
+// Parse parses the shell command or arguments in the template, returning a copy of the template
+// that does not need to be parsed again each time it's run.
+func (t Template) Parse() (Template, error) {
+	parsed := t
+	parsed.parsedArgs = []*template.Template{}
+	if len(t.Args) == 0 {
+		parsedShell, parseErr := ParseTemplate(t.Shell)
+		if parseErr != nil {
+			return Template{}, parseErr
+		}
+		parsed.parsedShell = parsedShell
+		return parsed, nil
+	}
+	for curIndex, curArg := range t.Args {
+		parsedArg, parseErr := ParseTemplate(curArg)
+		if parseErr != nil {
+			return Template{}, fmt.Errorf("Argument %d: %w", curIndex, parseErr)
+		}
+		parsed.parsedArgs = append(parsed.parsedArgs, parsedArg)
+	}
+
+	return parsed, nil
+}
+
+// GetCallerKey returns a key identifying the caller in the state, made up of the caller variables
+// used by the template, and of the caller's user and group IDs if PerCaller is set. Output of the
+// command for states with the same key can be shared. Returns a blank string if the output of the
+// command does not depend on the caller.
+func (t Template) GetCallerKey(s *State) string {
+	texts := append([]string{t.Shell}, t.Args...)
+	values := []string{}
+	for _, curVariable := range callerVariables {
+		isUsed := t.PerCaller && (curVariable.field == "CallerUID" || curVariable.field == "CallerGID")
+		for _, curText := range texts {
+			if strings.Contains(curText, "."+curVariable.field) || strings.Contains(curText, curVariable.envVar) {
+				isUsed = true
+				break
+			}
+		}
+		if isUsed {
+			values = append(values, curVariable.field+"="+s.getCallerValue(curVariable.field))
+		}
+	}
+
+	return strings.Join(values, "\x00")
+}
+
+// UsesCaller returns true if the output of the command depends on the caller. Only such commands
+// are given the caller.
+func (t Template) UsesCaller() bool {
+	return len(t.GetCallerKey(&State{})) > 0
+}
+
+func (t Template) isParsed() bool {
+	return t.parsedShell != nil || len(t.parsedArgs) > 0
+}
+
+// IsSet returns true if either the shell command, the arguments or the backend are set.
+func (t Template) IsSet() bool {
+	return len(t.Shell) > 0 || len(t.Args) > 0 || t.Backend != nil
+}
+
 type Command struct {
+	ctx         context.Context
+	kind        string
+	timeout     time.Duration
 	state       *State
-	template    string
-	postRunHook func([]byte, error)
+	template    Template
+	postRunHook func([]byte, []byte, error)
+	process     *os.Process
+	// Cancels the request sent to the template's backend
+	cancelBackendRequest context.CancelFunc
+	processMutex         *sync.Mutex
+	queuedAt             time.Time
 }
 
 type State struct {
@@ -19,6 +145,13 @@ type State struct {
 	MountRootDirPath string
 	RelativePath     string
 	Name             string
+	// The identity of the process that made the FUSE request the command is run for. Not set if
+	// the command is not run for a FUSE request
+	CallerUID  uint32
+	CallerGID  uint32
+	CallerPID  uint32
+	CallerComm string
+	CallerExe  string
 }
 
 func NewState(mountName string, mountRootDirPath string, relativePath string, fileName string) *State {
@@ -36,23 +169,121 @@ func CopyState(original *State) *State {
 		MountRootDirPath: original.MountRootDirPath,
 		RelativePath:     original.RelativePath,
 		Name:             original.Name,
+		CallerUID:        original.CallerUID,
+		CallerGID:        original.CallerGID,
+		CallerPID:        original.CallerPID,
+		CallerComm:       original.CallerComm,
+		CallerExe:        original.CallerExe,
+	}
+}
+
+// callerVariables are the names of the state's caller fields, and of the environment variables
+// they are exposed to commands as.
+var callerVariables = []struct {
+	field  string
+	envVar string
+}{
+	{"CallerUID", "FUSEE_CALLER_UID"},
+	{"CallerGID", "FUSEE_CALLER_GID"},
+	{"CallerPID", "FUSEE_CALLER_PID"},
+	{"CallerComm", "FUSEE_CALLER_COMM"},
+	{"CallerExe", "FUSEE_CALLER_EXE"},
+}
+
+func (s *State) getCallerValue(field string) string {
+	switch field {
+	case "CallerUID":
+		return strconv.FormatUint(uint64(s.CallerUID), 10)
+	case "CallerGID":
+		return strconv.FormatUint(uint64(s.CallerGID), 10)
+	case "CallerPID":
+		return strconv.FormatUint(uint64(s.CallerPID), 10)
+	case "CallerComm":
+		return s.CallerComm
+	case "CallerExe":
+		return s.CallerExe
+	}
+
+	return ""
+}
+
+// getEnv returns the environment variables exposing the state to commands. The caller variables
+// are only included if the state has a caller.
+func (s *State) getEnv() []string {
+	depth := 0
+	if len(s.RelativePath) > 0 {
+		depth = strings.Count(s.RelativePath, string(os.PathSeparator)) + 1
+	}
+	env := []string{
+		"FUSEE_MOUNT_NAME=" + s.MountName,
+		"FUSEE_MOUNT_ROOT=" + s.MountRootDirPath,
+		"FUSEE_RELATIVE_PATH=" + s.RelativePath,
+		"FUSEE_NAME=" + s.Name,
+		"FUSEE_DEPTH=" + strconv.Itoa(depth),
+	}
+	if s.CallerPID == 0 {
+		return env
 	}
+	for _, curVariable := range callerVariables {
+		env = append(env, curVariable.envVar+"="+s.getCallerValue(curVariable.field))
+	}
+
+	return env
 }
 
-func NewCommand(template string, state *State, postRunHook func([]byte, error)) *Command {
+// NewCommand creates a command that is killed if ctx is canceled or, if timeout is not 0, if it
+// runs for longer than the timeout. Once the command has run, postRunHook is called with what the
+// command wrote to stdout and stderr, and the error returned when running it.
+func NewCommand(ctx context.Context, kind string, template Template, timeout time.Duration, state *State, postRunHook func([]byte, []byte, error)) *Command {
 	return &Command{
-		state:       state,
-		template:    template,
-		postRunHook: postRunHook,
+		ctx:          ctx,
+		kind:         kind,
+		timeout:      timeout,
+		state:        state,
+		template:     template,
+		postRunHook:  postRunHook,
+		processMutex: new(sync.Mutex),
 	}
 }
 
-func (c *Command) constructCommand() (string, error) {
-	t, tErr := template.New("Command").Parse(c.template)
-	if tErr != nil {
-		return "", tErr
+// ParseTemplate parses a command template, returning an error if the template is invalid. The
+// functions in templateFuncs can be used in the template.
+func ParseTemplate(text string) (*template.Template, error) {
+	return template.New("Command").Funcs(templateFuncs).Parse(text)
+}
+
+// constructCommand fills in the command's template and returns the arguments to execute. The
+// template is parsed first if it hasn't been.
+func (c *Command) constructCommand() ([]string, error) {
+	parsedTemplate := c.template
+	if !parsedTemplate.isParsed() {
+		var parseErr error
+		parsedTemplate, parseErr = c.template.Parse()
+		if parseErr != nil {
+			return []string{}, parseErr
+		}
+	}
+	if parsedTemplate.parsedShell != nil {
+		shellCommand, shellCommandErr := c.executeTemplate(parsedTemplate.parsedShell)
+		if shellCommandErr != nil {
+			return []string{}, shellCommandErr
+		}
+		return []string{"sh", "-c", shellCommand}, nil
+	}
+
+	args := []string{}
+	for _, curArg := range parsedTemplate.parsedArgs {
+		arg, argErr := c.executeTemplate(curArg)
+		if argErr != nil {
+			return []string{}, argErr
+		}
+		args = append(args, arg)
 	}
 
+	return args, nil
+}
+
+func (c *Command) executeTemplate(t *template.Template) (string, error) {
 	var CommandBuf bytes.Buffer
 	execErr := t.Execute(&CommandBuf, *c.state)
 	if execErr != nil {
@@ -63,17 +294,195 @@ func (c *Command) constructCommand() (string, error) {
 }
 
 func (c *Command) Run() {
-	Command, CommandErr := c.constructCommand()
+	startTime := time.Now()
+	if c.ctx.Err() != nil {
+		observeExecution(c, startTime, ErrInterrupted)
+		c.abort(ErrInterrupted)
+		return
+	}
+	runCtx := c.ctx
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(c.ctx, c.timeout)
+		defer cancel()
+	}
+	if c.template.Backend != nil {
+		c.runOnBackend(runCtx, startTime)
+		return
+	}
+	args, CommandErr := c.constructCommand()
 	if CommandErr != nil {
+		observeExecution(c, startTime, CommandErr)
 		if c.postRunHook != nil {
-			c.postRunHook([]byte{}, CommandErr)
+			c.postRunHook([]byte{}, []byte{}, CommandErr)
 		}
 		return
 	}
 
-	output, outputErr := exec.Command("sh", "-c", Command).Output()
+	var output bytes.Buffer
+	var stderr bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = &output
+	cmd.Stderr = &stderr
+	cmd.Env = c.getEnv()
+	cmd.Dir = c.template.WorkingDir
+	// Run the command in its own process group so that it, and any process it spawns, can be
+	// killed together
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	outputErr := cmd.Start()
+	if outputErr == nil {
+		c.setProcess(cmd.Process)
+		waitDone := make(chan struct{})
+		go func() {
+			select {
+			case <-runCtx.Done():
+				c.Kill()
+			case <-waitDone:
+			}
+		}()
+		outputErr = cmd.Wait()
+		close(waitDone)
+		c.setProcess(nil)
+		outputErr = c.getContextErr(runCtx, outputErr)
+	}
+	observeExecution(c, startTime, outputErr)
+	recordExecution(c, args, startTime, output.Len(), outputErr)
 	log.Debug("About to run postRunHook")
 	if c.postRunHook != nil {
-		c.postRunHook(output, outputErr)
+		c.postRunHook(output.Bytes(), stderr.Bytes(), outputErr)
+	}
+}
+
+// runOnBackend sends the command to the template's backend instead of running it as a process.
+func (c *Command) runOnBackend(runCtx context.Context, startTime time.Time) {
+	requestCtx, cancel := context.WithCancel(runCtx)
+	defer cancel()
+	c.processMutex.Lock()
+	c.cancelBackendRequest = cancel
+	c.processMutex.Unlock()
+	output, stderr, requestErr := c.template.Backend.Run(requestCtx, c.kind, c.getCommandState())
+	c.processMutex.Lock()
+	c.cancelBackendRequest = nil
+	c.processMutex.Unlock()
+	requestErr = c.getContextErr(runCtx, requestErr)
+	if requestCtx.Err() != nil && runCtx.Err() == nil {
+		// The request was canceled by Kill
+		requestErr = ErrInterrupted
+	}
+
+	observeExecution(c, startTime, requestErr)
+	recordExecution(c, []string{}, startTime, len(output), requestErr)
+	if c.postRunHook != nil {
+		c.postRunHook(output, stderr, requestErr)
+	}
+}
+
+// getContextErr returns ErrTimedOut or ErrInterrupted if runCtx timed out or was canceled while
+// the command was running. Otherwise, runErr is returned.
+func (c *Command) getContextErr(runCtx context.Context, runErr error) error {
+	if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		log.Warnf("Command for '%s' timed out after %v", c.state.RelativePath, c.timeout)
+		return ErrTimedOut
+	}
+	if runCtx.Err() != nil {
+		log.Warnf("Command for '%s' was interrupted", c.state.RelativePath)
+		return ErrInterrupted
+	}
+
+	return runErr
+}
+
+// getEnv returns the environment the command should be run with. Variables set in the template
+// override fusee's, and the FUSEE_* variables override both.
+func (c *Command) getEnv() []string {
+	return append(getBaseEnv(c.template.CleanEnv, c.template.Env), c.getCommandState().getEnv()...)
+}
+
+// getCommandState returns the state the command is run with. The caller is removed from the state
+// unless the output of the command is keyed on the caller, so that output cached for one caller
+// cannot depend on who that caller was.
+func (c *Command) getCommandState() *State {
+	if c.template.UsesCaller() {
+		return c.state
+	}
+	commandState := CopyState(c.state)
+	commandState.CallerUID = 0
+	commandState.CallerGID = 0
+	commandState.CallerPID = 0
+	commandState.CallerComm = ""
+	commandState.CallerExe = ""
+
+	return commandState
+}
+
+// getBaseEnv returns fusee's environment variables, unless cleanEnv is true, with the variables
+// in env added.
+func getBaseEnv(cleanEnv bool, env map[string]string) []string {
+	baseEnv := []string{}
+	if !cleanEnv {
+		baseEnv = append(baseEnv, os.Environ()...)
+	}
+	names := []string{}
+	for curName := range env {
+		names = append(names, curName)
+	}
+	sort.Strings(names)
+	for _, curName := range names {
+		baseEnv = append(baseEnv, curName+"="+env[curName])
+	}
+
+	return baseEnv
+}
+
+// GetExitCode returns the exit code of a command given the error returned when running it.
+// Returns false if the command did not exit normally, for instance because it could not be
+// started or was killed by a signal.
+func GetExitCode(runErr error) (int, bool) {
+	if runErr == nil {
+		return 0, true
+	}
+	var exitErr *exec.ExitError
+	if errors.As(runErr, &exitErr) && exitErr.ExitCode() >= 0 {
+		return exitErr.ExitCode(), true
+	}
+	var backendErr *BackendError
+	if errors.As(runErr, &backendErr) {
+		return backendErr.ExitCode, true
+	}
+
+	return -1, false
+}
+
+func (c *Command) setProcess(process *os.Process) {
+	c.processMutex.Lock()
+	defer c.processMutex.Unlock()
+	c.process = process
+}
+
+// Kill kills the process group of the command, or cancels the request sent to the backend, if the
+// command is running. Returns false if the command was not running.
+func (c *Command) Kill() bool {
+	c.processMutex.Lock()
+	defer c.processMutex.Unlock()
+	if c.cancelBackendRequest != nil {
+		c.cancelBackendRequest()
+		return true
+	}
+	if c.process == nil {
+		return false
+	}
+	killErr := syscall.Kill(-c.process.Pid, syscall.SIGKILL)
+	if killErr != nil {
+		log.Warnf("Unable to kill process group %d: %v", c.process.Pid, killErr)
+		return false
+	}
+
+	return true
+}
+
+// abort calls the command's postRunHook with the provided error without running the command.
+func (c *Command) abort(err error) {
+	if c.postRunHook != nil {
+		c.postRunHook([]byte{}, []byte{}, err)
 	}
 }
@@ -0,0 +1,156 @@
+package command
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCallerState(uid uint32, pid uint32) *State {
+	state := NewState("mount", "/mnt", "dir/file", "file")
+	state.CallerUID = uid
+	state.CallerGID = uid
+	state.CallerPID = pid
+	state.CallerComm = "cat"
+	state.CallerExe = "/usr/bin/cat"
+	return state
+}
+
+func TestGetCallerKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		template Template
+		expected string
+	}{
+		{"no caller variable", Template{Shell: "cat {{ .RelativePath }}"}, ""},
+		{"template variable", Template{Shell: "decrypt --uid {{ .CallerUID }}"}, "CallerUID=1000"},
+		{"environment variable", Template{Shell: `decrypt --uid "$FUSEE_CALLER_UID"`}, "CallerUID=1000"},
+		{"argument", Template{Args: []string{"decrypt", "{{ .CallerExe }}"}}, "CallerExe=/usr/bin/cat"},
+		{"several variables", Template{Shell: "decrypt {{ .CallerPID }} {{ .CallerGID }}"}, "CallerGID=1000\x00CallerPID=42"},
+		{"per caller", Template{Shell: "decrypt-for-caller", PerCaller: true}, "CallerUID=1000\x00CallerGID=1000"},
+		{"per caller with variable", Template{Shell: "decrypt {{ .CallerComm }}", PerCaller: true}, "CallerUID=1000\x00CallerGID=1000\x00CallerComm=cat"},
+		{"backend", Template{Backend: &testBackend{}}, ""},
+		{"per caller backend", Template{Backend: &testBackend{}, PerCaller: true}, "CallerUID=1000\x00CallerGID=1000"},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			if key := curTest.template.GetCallerKey(newCallerState(1000, 42)); key != curTest.expected {
+				t.Errorf("Expected key '%q', got '%q'", curTest.expected, key)
+			}
+			if usesCaller := curTest.template.UsesCaller(); usesCaller != (len(curTest.expected) > 0) {
+				t.Errorf("Expected UsesCaller to be %t", len(curTest.expected) > 0)
+			}
+		})
+	}
+}
+
+// testBackend outputs the user ID of the caller it is given.
+type testBackend struct{}
+
+func (b *testBackend) Run(ctx context.Context, kind string, state *State) ([]byte, []byte, error) {
+	return []byte(state.getCallerValue("CallerUID")), []byte{}, nil
+}
+
+func (b *testBackend) Describe(kind string, state *State) string {
+	return kind
+}
+
+func runTestCommand(t *testing.T, template Template, state *State) string {
+	t.Helper()
+	parsed, parseErr := template.Parse()
+	if parseErr != nil {
+		t.Fatalf("Unable to parse template: %v", parseErr)
+	}
+	var output []byte
+	NewCommand(context.Background(), KindRead, parsed, 10*time.Second, state, func(commandOutput []byte, stderr []byte, commandErr error) {
+		if commandErr != nil {
+			t.Errorf("Command failed: %v: %s", commandErr, stderr)
+		}
+		output = commandOutput
+	}).Run()
+
+	return strings.TrimSpace(string(output))
+}
+
+// TestCallerOnlyGivenToPerCallerCommands checks that the output of commands not keyed on the
+// caller cannot depend on the caller.
+func TestCallerOnlyGivenToPerCallerCommands(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "script.sh")
+	if writeErr := os.WriteFile(script, []byte("echo \"uid:$FUSEE_CALLER_UID\"\n"), 0o755); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	tests := []struct {
+		name     string
+		template Template
+		expected []string
+	}{
+		{"script reading the environment", Template{Shell: "sh " + script}, []string{"uid:", "uid:"}},
+		{"per caller script", Template{Shell: "sh " + script, PerCaller: true}, []string{"uid:1000", "uid:1001"}},
+		{"template using the environment variable", Template{Shell: "echo \"uid:$FUSEE_CALLER_UID\""}, []string{"uid:1000", "uid:1001"}},
+		{"backend", Template{Backend: &testBackend{}}, []string{"0", "0"}},
+		{"per caller backend", Template{Backend: &testBackend{}, PerCaller: true}, []string{"1000", "1001"}},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			for curIndex, curUID := range []uint32{1000, 1001} {
+				state := newCallerState(curUID, 42)
+				if output := runTestCommand(t, curTest.template, state); output != curTest.expected[curIndex] {
+					t.Errorf("Expected '%s' for %d, got '%s'", curTest.expected[curIndex], curUID, output)
+				}
+				if state.CallerUID != curUID {
+					t.Error("The state passed to the command was changed")
+				}
+			}
+		})
+	}
+}
+
+func TestGetExitCode(t *testing.T) {
+	exitErr := runTestCommandErr(t, "exit 3")
+	tests := []struct {
+		name           string
+		err            error
+		expectedCode   int
+		expectedExited bool
+	}{
+		{"no error", nil, 0, true},
+		{"exit code", exitErr, 3, true},
+		{"backend error", &BackendError{ExitCode: 2}, 2, true},
+		{"timed out", ErrTimedOut, -1, false},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			exitCode, exited := GetExitCode(curTest.err)
+			if exitCode != curTest.expectedCode || exited != curTest.expectedExited {
+				t.Errorf("Expected %d, %t, got %d, %t", curTest.expectedCode, curTest.expectedExited, exitCode, exited)
+			}
+		})
+	}
+}
+
+func runTestCommandErr(t *testing.T, shell string) error {
+	t.Helper()
+	var runErr error
+	NewCommand(context.Background(), KindRead, Template{Shell: shell}, 0, NewState("mount", "/mnt", "", ""), func(output []byte, stderr []byte, commandErr error) {
+		runErr = commandErr
+	}).Run()
+
+	return runErr
+}
+
+func TestTimeout(t *testing.T) {
+	startTime := time.Now()
+	var runErr error
+	NewCommand(context.Background(), KindRead, Template{Shell: "sleep 10"}, 100*time.Millisecond, NewState("mount", "/mnt", "", ""), func(output []byte, stderr []byte, commandErr error) {
+		runErr = commandErr
+	}).Run()
+	if runErr != ErrTimedOut {
+		t.Errorf("Expected the command to time out, got '%v'", runErr)
+	}
+	if time.Since(startTime) > 5*time.Second {
+		t.Error("The command was not killed when it timed out")
+	}
+}
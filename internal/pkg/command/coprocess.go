@@ -0,0 +1,381 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Backend runs commands without starting a process for each of them.
+type Backend interface {
+	// Run runs the command of the provided kind for state, returning what would have been written
+	// to the command's stdout and stderr
+	Run(ctx context.Context, kind string, state *State) ([]byte, []byte, error)
+	// Describe returns the text recorded in the audit log for the command
+	Describe(kind string, state *State) string
+}
+
+// BackendNameSeparator separates the names of the entries a Backend outputs for list commands.
+const BackendNameSeparator = "\x00"
+
+// BackendError is returned by a Backend when a command fails. ExitCode is treated like the exit
+// code of a command run as a process.
+type BackendError struct {
+	ExitCode int
+	// If set, FUSE operations fail with Errno instead of the errno ExitCode is mapped to
+	Errno   syscall.Errno
+	Message string
+}
+
+func (e *BackendError) Error() string {
+	if len(e.Message) == 0 {
+		return fmt.Sprintf("Exit code %d", e.ExitCode)
+	}
+
+	return fmt.Sprintf("Exit code %d: %s", e.ExitCode, e.Message)
+}
+
+// ErrCoprocessStopped is returned for requests sent to a coprocess after it was stopped.
+var ErrCoprocessStopped = errors.New("Coprocess stopped")
+
+// ErrCoprocessExited is returned for requests still in flight when a coprocess exits.
+var ErrCoprocessExited = errors.New("Coprocess exited before responding")
+
+// The methods of the requests sent to a coprocess, by the kind of the command
+var coprocessMethods = map[string]string{
+	KindList:  "list",
+	KindProbe: "stat",
+	KindRead:  "read",
+}
+
+// The types of entries a coprocess can respond to a stat request with
+const (
+	coprocessTypeDirectory = "dir"
+	coprocessTypeFile      = "file"
+)
+
+type coprocessRequest struct {
+	ID     uint64          `json:"id"`
+	Method string          `json:"method"`
+	Params coprocessParams `json:"params"`
+}
+
+type coprocessParams struct {
+	Mount  string           `json:"mount"`
+	Path   string           `json:"path"`
+	Name   string           `json:"name"`
+	Caller *coprocessCaller `json:"caller,omitempty"`
+}
+
+type coprocessCaller struct {
+	UID  uint32 `json:"uid"`
+	GID  uint32 `json:"gid"`
+	PID  uint32 `json:"pid"`
+	Comm string `json:"comm"`
+	Exe  string `json:"exe"`
+}
+
+type coprocessResponse struct {
+	ID     uint64           `json:"id"`
+	Result *coprocessResult `json:"result"`
+	Error  *coprocessError  `json:"error"`
+}
+
+type coprocessResult struct {
+	// Set for list requests, and optionally for stat requests for directories
+	Entries []string `json:"entries"`
+	// Set for stat requests
+	Type string `json:"type"`
+	// One of these is set for read requests
+	Content       *string `json:"content"`
+	ContentBase64 *string `json:"contentBase64"`
+}
+
+type coprocessError struct {
+	ExitCode int    `json:"exitCode"`
+	Message  string `json:"message"`
+}
+
+// Coprocess is a Backend that sends commands, as newline-delimited JSON requests, to a helper
+// process that is kept running. Requests are sent to the helper's stdin and matched with the
+// responses it writes to its stdout using their IDs, so that several requests can be in flight at
+// once. The helper is started on the first request, and started again on the next request if it
+// exits.
+type Coprocess struct {
+	mountName      string
+	args           []string
+	env            []string
+	workingDir     string
+	stderrLogLevel log.Level
+	process        *exec.Cmd
+	stdin          io.WriteCloser
+	pending        map[uint64]chan coprocessResponse
+	lastID         uint64
+	stopped        bool
+	mutex          *sync.Mutex
+	// Held while writing a request so that requests are not interleaved
+	writeMutex *sync.Mutex
+}
+
+// NewCoprocess returns a coprocess for the mount that runs the helper in args. Lines written by the
+// helper to its stderr are logged at stderrLogLevel.
+func NewCoprocess(mountName string, args []string, env map[string]string, workingDir string, cleanEnv bool, stderrLogLevel log.Level) *Coprocess {
+	return &Coprocess{
+		mountName:      mountName,
+		args:           args,
+		env:            getBaseEnv(cleanEnv, env),
+		workingDir:     workingDir,
+		stderrLogLevel: stderrLogLevel,
+		pending:        map[uint64]chan coprocessResponse{},
+		mutex:          new(sync.Mutex),
+		writeMutex:     new(sync.Mutex),
+	}
+}
+
+// Run sends a request for the command to the helper and waits for its response.
+func (c *Coprocess) Run(ctx context.Context, kind string, state *State) ([]byte, []byte, error) {
+	method, methodFound := coprocessMethods[kind]
+	if !methodFound {
+		return []byte{}, []byte{}, fmt.Errorf("Coprocess does not support commands of kind '%s'", kind)
+	}
+	id, responseChan, sendErr := c.send(method, state)
+	if sendErr != nil {
+		return []byte{}, []byte{}, sendErr
+	}
+
+	var response coprocessResponse
+	select {
+	case <-ctx.Done():
+		c.mutex.Lock()
+		delete(c.pending, id)
+		c.mutex.Unlock()
+		return []byte{}, []byte{}, ctx.Err()
+	case curResponse, ok := <-responseChan:
+		if !ok {
+			return []byte{}, []byte{}, ErrCoprocessExited
+		}
+		response = curResponse
+	}
+	if response.Error != nil {
+		return []byte{}, []byte(response.Error.Message), &BackendError{
+			ExitCode: response.Error.ExitCode,
+			Message:  response.Error.Message,
+		}
+	}
+	if response.Result == nil {
+		return []byte{}, []byte{}, fmt.Errorf("Coprocess response %d has neither a result nor an error", id)
+	}
+
+	return getCoprocessOutput(method, response.Result)
+}
+
+// Describe returns the helper, method and path of the request sent for the command.
+func (c *Coprocess) Describe(kind string, state *State) string {
+	words := []string{}
+	for _, curArg := range c.args {
+		words = append(words, shellQuote(curArg))
+	}
+
+	return strings.Join(append(words, coprocessMethods[kind], shellQuote(state.RelativePath)), " ")
+}
+
+// Stop kills the helper. Requests in flight fail, as do requests sent after the coprocess is
+// stopped.
+func (c *Coprocess) Stop() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.stopped = true
+	if c.process == nil {
+		return
+	}
+	if killErr := syscall.Kill(-c.process.Process.Pid, syscall.SIGKILL); killErr != nil {
+		log.Warnf("Unable to kill coprocess for mount '%s': %v", c.mountName, killErr)
+	}
+}
+
+// send writes a request to the helper, starting it if it's not running. Returns the ID of the
+// request and the channel its response is sent to.
+func (c *Coprocess) send(method string, state *State) (uint64, chan coprocessResponse, error) {
+	c.mutex.Lock()
+	if c.stopped {
+		c.mutex.Unlock()
+		return 0, nil, ErrCoprocessStopped
+	}
+	if c.process == nil {
+		if startErr := c.start(); startErr != nil {
+			c.mutex.Unlock()
+			return 0, nil, startErr
+		}
+	}
+	c.lastID++
+	id := c.lastID
+	stdin := c.stdin
+	// Buffered so that the reader never blocks on a request that is no longer waiting
+	responseChan := make(chan coprocessResponse, 1)
+	c.pending[id] = responseChan
+	c.mutex.Unlock()
+
+	request := coprocessRequest{
+		ID:     id,
+		Method: method,
+		Params: coprocessParams{
+			Mount: state.MountName,
+			Path:  state.RelativePath,
+			Name:  state.Name,
+		},
+	}
+	if state.CallerPID != 0 {
+		request.Params.Caller = &coprocessCaller{
+			UID:  state.CallerUID,
+			GID:  state.CallerGID,
+			PID:  state.CallerPID,
+			Comm: state.CallerComm,
+			Exe:  state.CallerExe,
+		}
+	}
+	line, marshalErr := json.Marshal(request)
+	if marshalErr == nil {
+		// Written without holding the mutex so that responses can still be read while the helper
+		// is not reading its stdin
+		c.writeMutex.Lock()
+		_, writeErr := stdin.Write(append(line, '\n'))
+		c.writeMutex.Unlock()
+		if writeErr != nil {
+			marshalErr = fmt.Errorf("Unable to send request to coprocess: %w", writeErr)
+		}
+	}
+	if marshalErr != nil {
+		c.mutex.Lock()
+		delete(c.pending, id)
+		c.mutex.Unlock()
+		return 0, nil, marshalErr
+	}
+
+	return id, responseChan, nil
+}
+
+// start starts the helper. Should be called with the mutex locked.
+func (c *Coprocess) start() error {
+	if len(c.args) == 0 {
+		return errors.New("Coprocess command not provided")
+	}
+	log.Info(fmt.Sprintf("Starting coprocess for mount '%s'", c.mountName))
+	process := exec.Command(c.args[0], c.args[1:]...)
+	process.Env = c.env
+	process.Dir = c.workingDir
+	process.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdin, stdinErr := process.StdinPipe()
+	if stdinErr != nil {
+		return stdinErr
+	}
+	stdout, stdoutErr := process.StdoutPipe()
+	if stdoutErr != nil {
+		return stdoutErr
+	}
+	stderr, stderrErr := process.StderrPipe()
+	if stderrErr != nil {
+		return stderrErr
+	}
+	if startErr := process.Start(); startErr != nil {
+		return fmt.Errorf("Unable to start coprocess: %w", startErr)
+	}
+	c.process = process
+	c.stdin = stdin
+	go c.logStderr(stderr)
+	go c.readResponses(process, stdout)
+
+	return nil
+}
+
+// readResponses passes the responses written by the helper to the requests waiting for them until
+// the helper exits.
+func (c *Coprocess) readResponses(process *exec.Cmd, stdout io.Reader) {
+	reader := bufio.NewReader(stdout)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			c.dispatch(line)
+		}
+		if readErr != nil {
+			break
+		}
+	}
+	waitErr := process.Wait()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.stopped {
+		log.Warn(fmt.Sprintf("Coprocess for mount '%s' exited: %v", c.mountName, waitErr))
+	}
+	c.process = nil
+	c.stdin = nil
+	for curID, curChan := range c.pending {
+		close(curChan)
+		delete(c.pending, curID)
+	}
+}
+
+func (c *Coprocess) dispatch(line []byte) {
+	var response coprocessResponse
+	if unmarshalErr := json.Unmarshal(line, &response); unmarshalErr != nil {
+		log.Warn(fmt.Sprintf("Ignoring invalid response from coprocess for mount '%s': %v", c.mountName, unmarshalErr))
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	responseChan, found := c.pending[response.ID]
+	if !found {
+		log.Debug(fmt.Sprintf("Ignoring response %d from coprocess for mount '%s' since no request is waiting for it", response.ID, c.mountName))
+		return
+	}
+	delete(c.pending, response.ID)
+	responseChan <- response
+}
+
+func (c *Coprocess) logStderr(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		log.StandardLogger().Log(c.stderrLogLevel, fmt.Sprintf("Coprocess for mount '%s' wrote to stderr: %s", c.mountName, scanner.Text()))
+	}
+}
+
+// getCoprocessOutput returns the output a command run as a process would have written for the
+// result of a request.
+func getCoprocessOutput(method string, result *coprocessResult) ([]byte, []byte, error) {
+	switch method {
+	case coprocessMethods[KindProbe]:
+		if result.Type == coprocessTypeFile {
+			// Probe commands that fail are for files
+			return []byte{}, []byte{}, &BackendError{ExitCode: 1}
+		}
+		if result.Type != coprocessTypeDirectory {
+			return []byte{}, []byte{}, fmt.Errorf("Unknown entry type '%s' in coprocess response", result.Type)
+		}
+		return []byte(strings.Join(result.Entries, BackendNameSeparator)), []byte{}, nil
+	case coprocessMethods[KindRead]:
+		if result.ContentBase64 != nil {
+			content, decodeErr := base64.StdEncoding.DecodeString(*result.ContentBase64)
+			if decodeErr != nil {
+				return []byte{}, []byte{}, fmt.Errorf("Unable to decode content in coprocess response: %w", decodeErr)
+			}
+			return content, []byte{}, nil
+		}
+		if result.Content != nil {
+			return []byte(*result.Content), []byte{}, nil
+		}
+		return []byte{}, []byte{}, errors.New("Coprocess response has no content")
+	}
+
+	return []byte(strings.Join(result.Entries, BackendNameSeparator)), []byte{}, nil
+}
@@ -0,0 +1,225 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TestHelperCoprocess is not a test. It is the helper run by the coprocess in the other tests,
+// responding to requests based on their path.
+func TestHelperCoprocess(t *testing.T) {
+	if os.Getenv("FUSEE_TEST_COPROCESS") != "1" {
+		return
+	}
+	var writeMutex sync.Mutex
+	respond := func(response string) {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		fmt.Println(response)
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var request coprocessRequest
+		if unmarshalErr := json.Unmarshal(scanner.Bytes(), &request); unmarshalErr != nil {
+			fmt.Fprintf(os.Stderr, "Invalid request: %v\n", unmarshalErr)
+			continue
+		}
+		id := request.ID
+		switch request.Method + " " + request.Params.Path {
+		case "list dir":
+			respond(fmt.Sprintf(`{"id": %d, "result": {"entries": ["a", "b c"]}}`, id))
+		case "stat dir":
+			respond(fmt.Sprintf(`{"id": %d, "result": {"type": "dir", "entries": ["a"]}}`, id))
+		case "stat file":
+			respond(fmt.Sprintf(`{"id": %d, "result": {"type": "file"}}`, id))
+		case "stat socket":
+			respond(fmt.Sprintf(`{"id": %d, "result": {"type": "socket"}}`, id))
+		case "read text":
+			respond(fmt.Sprintf(`{"id": %d, "result": {"content": "%s/%s"}}`, id, request.Params.Mount, request.Params.Name))
+		case "read binary":
+			respond(fmt.Sprintf(`{"id": %d, "result": {"contentBase64": "AAEC"}}`, id))
+		case "read invalid-base64":
+			respond(fmt.Sprintf(`{"id": %d, "result": {"contentBase64": "not base64!"}}`, id))
+		case "read no-content":
+			respond(fmt.Sprintf(`{"id": %d, "result": {}}`, id))
+		case "read no-result":
+			respond(fmt.Sprintf(`{"id": %d}`, id))
+		case "read missing":
+			respond(fmt.Sprintf(`{"id": %d, "error": {"exitCode": 2, "message": "not found"}}`, id))
+		case "read caller":
+			caller := "none"
+			if request.Params.Caller != nil {
+				caller = fmt.Sprintf("%d", request.Params.Caller.UID)
+			}
+			respond(fmt.Sprintf(`{"id": %d, "result": {"content": "%s"}}`, id, caller))
+		case "read garbage":
+			respond("not json")
+			respond(fmt.Sprintf(`{"id": %d, "result": {"content": "after garbage"}}`, id))
+		case "read slow":
+			// Responded to after requests sent later
+			go func() {
+				time.Sleep(300 * time.Millisecond)
+				respond(fmt.Sprintf(`{"id": %d, "result": {"content": "slow"}}`, id))
+			}()
+		case "read hang":
+		case "read exit":
+			os.Exit(1)
+		default:
+			respond(fmt.Sprintf(`{"id": %d, "error": {"exitCode": 1, "message": "unexpected request"}}`, id))
+		}
+	}
+	os.Exit(0)
+}
+
+func newTestCoprocess(t *testing.T) *Coprocess {
+	t.Helper()
+	coprocess := NewCoprocess("mount", []string{os.Args[0], "-test.run=^TestHelperCoprocess$"}, map[string]string{"FUSEE_TEST_COPROCESS": "1"}, "", false, log.DebugLevel)
+	t.Cleanup(coprocess.Stop)
+	return coprocess
+}
+
+func TestCoprocessRun(t *testing.T) {
+	coprocess := newTestCoprocess(t)
+	tests := []struct {
+		name             string
+		kind             string
+		path             string
+		expectedOutput   string
+		expectedExitCode int
+		expectErr        bool
+	}{
+		{"list", KindList, "dir", "a\x00b c", 0, false},
+		{"stat directory", KindProbe, "dir", "a", 0, false},
+		{"stat file", KindProbe, "file", "", 1, true},
+		{"stat unknown type", KindProbe, "socket", "", -1, true},
+		{"read", KindRead, "text", "mount/text", 0, false},
+		{"read base64", KindRead, "binary", "\x00\x01\x02", 0, false},
+		{"read invalid base64", KindRead, "invalid-base64", "", -1, true},
+		{"read without content", KindRead, "no-content", "", -1, true},
+		{"neither result nor error", KindRead, "no-result", "", -1, true},
+		{"error", KindRead, "missing", "", 2, true},
+		{"no caller", KindRead, "caller", "none", 0, false},
+		{"invalid response ignored", KindRead, "garbage", "after garbage", 0, false},
+		{"unsupported kind", KindType, "file", "", -1, true},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			output, _, runErr := coprocess.Run(context.Background(), curTest.kind, NewState("mount", "/mnt", curTest.path, curTest.path))
+			if (runErr != nil) != curTest.expectErr {
+				t.Fatalf("Expected an error: %t, got '%v'", curTest.expectErr, runErr)
+			}
+			if string(output) != curTest.expectedOutput {
+				t.Errorf("Expected '%q', got '%q'", curTest.expectedOutput, output)
+			}
+			var backendErr *BackendError
+			if isBackendErr := errors.As(runErr, &backendErr); isBackendErr != (curTest.expectedExitCode > 0) {
+				t.Fatalf("Expected a backend error: %t, got '%v'", curTest.expectedExitCode > 0, runErr)
+			}
+			if backendErr != nil && backendErr.ExitCode != curTest.expectedExitCode {
+				t.Errorf("Expected exit code %d, got %d", curTest.expectedExitCode, backendErr.ExitCode)
+			}
+		})
+	}
+}
+
+func TestCoprocessCaller(t *testing.T) {
+	coprocess := newTestCoprocess(t)
+	state := newCallerState(1000, 42)
+	state.RelativePath = "caller"
+	output, _, runErr := coprocess.Run(context.Background(), KindRead, state)
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+	if string(output) != "1000" {
+		t.Errorf("Expected the caller's UID in the request, got '%s'", output)
+	}
+}
+
+// TestCoprocessConcurrentRequests checks that responses are matched with requests using their
+// IDs, not the order they are written in.
+func TestCoprocessConcurrentRequests(t *testing.T) {
+	coprocess := newTestCoprocess(t)
+	var wg sync.WaitGroup
+	outputs := make([]string, 4)
+	for curIndex, curPath := range []string{"slow", "text", "binary", "text"} {
+		wg.Add(1)
+		go func(index int, path string) {
+			defer wg.Done()
+			output, _, runErr := coprocess.Run(context.Background(), KindRead, NewState("mount", "/mnt", path, path))
+			if runErr != nil {
+				t.Errorf("Request for '%s' failed: %v", path, runErr)
+			}
+			outputs[index] = string(output)
+		}(curIndex, curPath)
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+	expected := []string{"slow", "mount/text", "\x00\x01\x02", "mount/text"}
+	if strings.Join(outputs, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %q, got %q", expected, outputs)
+	}
+}
+
+func TestCoprocessCanceled(t *testing.T) {
+	coprocess := newTestCoprocess(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, _, runErr := coprocess.Run(ctx, KindRead, NewState("mount", "/mnt", "hang", "hang")); !errors.Is(runErr, context.DeadlineExceeded) {
+		t.Errorf("Expected the request to be canceled, got '%v'", runErr)
+	}
+	if output, _, runErr := coprocess.Run(context.Background(), KindRead, NewState("mount", "/mnt", "text", "text")); runErr != nil || string(output) != "mount/text" {
+		t.Errorf("Expected the coprocess to keep responding, got '%s', '%v'", output, runErr)
+	}
+}
+
+// TestCoprocessRestarted checks that requests in flight fail when the helper exits and that the
+// helper is started again on the next request.
+func TestCoprocessRestarted(t *testing.T) {
+	coprocess := newTestCoprocess(t)
+	if _, _, runErr := coprocess.Run(context.Background(), KindRead, NewState("mount", "/mnt", "exit", "exit")); runErr != ErrCoprocessExited {
+		t.Errorf("Expected '%v', got '%v'", ErrCoprocessExited, runErr)
+	}
+	if output, _, runErr := coprocess.Run(context.Background(), KindRead, NewState("mount", "/mnt", "text", "text")); runErr != nil || string(output) != "mount/text" {
+		t.Errorf("Expected the coprocess to be restarted, got '%s', '%v'", output, runErr)
+	}
+}
+
+func TestCoprocessStopped(t *testing.T) {
+	coprocess := newTestCoprocess(t)
+	done := make(chan error)
+	go func() {
+		_, _, runErr := coprocess.Run(context.Background(), KindRead, NewState("mount", "/mnt", "hang", "hang"))
+		done <- runErr
+	}()
+	time.Sleep(200 * time.Millisecond)
+	coprocess.Stop()
+	select {
+	case runErr := <-done:
+		if runErr != ErrCoprocessExited {
+			t.Errorf("Expected '%v' for the request in flight, got '%v'", ErrCoprocessExited, runErr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The request in flight did not fail when the coprocess was stopped")
+	}
+	if _, _, runErr := coprocess.Run(context.Background(), KindRead, NewState("mount", "/mnt", "text", "text")); runErr != ErrCoprocessStopped {
+		t.Errorf("Expected '%v', got '%v'", ErrCoprocessStopped, runErr)
+	}
+}
+
+func TestCoprocessDescribe(t *testing.T) {
+	coprocess := NewCoprocess("mount", []string{"vault-helper", "--addr", "https://vault"}, nil, "", false, log.DebugLevel)
+	expected := "'vault-helper' '--addr' 'https://vault' read 'dir/it'\\''s'"
+	if description := coprocess.Describe(KindRead, NewState("mount", "/mnt", "dir/it's", "it's")); description != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, description)
+	}
+}
@@ -0,0 +1,83 @@
+package command
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are the functions usable in command templates. Functions taking a string to
+// transform take it as their last argument so that they can be used in pipelines, for example
+// {{ .RelativePath | replace "/" "-" }}.
+var templateFuncs = template.FuncMap{
+	"shellquote":   shellQuote,
+	"base":         filepath.Base,
+	"dir":          filepath.Dir,
+	"ext":          filepath.Ext,
+	"trimExt":      trimExt,
+	"split":        split,
+	"join":         join,
+	"replace":      replace,
+	"regexReplace": regexReplace,
+	"env":          os.Getenv,
+	"lower":        strings.ToLower,
+	"upper":        strings.ToUpper,
+	"default":      defaultValue,
+	"b64enc":       b64Encode,
+	"b64dec":       b64Decode,
+}
+
+// shellQuote quotes text so that sh treats it as a single word, no matter the characters in it.
+func shellQuote(text string) string {
+	return "'" + strings.ReplaceAll(text, "'", `'\''`) + "'"
+}
+
+func trimExt(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
+func split(separator string, text string) []string {
+	return strings.Split(text, separator)
+}
+
+func join(separator string, elements []string) string {
+	return strings.Join(elements, separator)
+}
+
+func replace(old string, new string, text string) string {
+	return strings.ReplaceAll(text, old, new)
+}
+
+func regexReplace(expression string, replacement string, text string) (string, error) {
+	regex, regexErr := regexp.Compile(expression)
+	if regexErr != nil {
+		return "", regexErr
+	}
+
+	return regex.ReplaceAllString(text, replacement), nil
+}
+
+// defaultValue returns value, or defaultText if value is blank.
+func defaultValue(defaultText string, value string) string {
+	if len(value) == 0 {
+		return defaultText
+	}
+
+	return value
+}
+
+func b64Encode(text string) string {
+	return base64.StdEncoding.EncodeToString([]byte(text))
+}
+
+func b64Decode(encoded string) (string, error) {
+	decoded, decodeErr := base64.StdEncoding.DecodeString(encoded)
+	if decodeErr != nil {
+		return "", decodeErr
+	}
+
+	return string(decoded), nil
+}
@@ -0,0 +1,85 @@
+package command
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func executeTestTemplate(t *testing.T, text string, state *State) (string, error) {
+	t.Helper()
+	parsed, parseErr := ParseTemplate(text)
+	if parseErr != nil {
+		t.Fatalf("Unable to parse '%s': %v", text, parseErr)
+	}
+	var output bytes.Buffer
+	execErr := parsed.Execute(&output, *state)
+	return output.String(), execErr
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	os.Setenv("FUSEE_TEST_VAR", "value")
+	defer os.Unsetenv("FUSEE_TEST_VAR")
+	state := NewState("mount", "/mnt", "dir/sub/file.tar.gz", "file.tar.gz")
+	tests := []struct {
+		template  string
+		expected  string
+		expectErr bool
+	}{
+		{`{{ shellquote "it's a file" }}`, `'it'\''s a file'`, false},
+		{`{{ shellquote "" }}`, `''`, false},
+		{`{{ base .RelativePath }}`, "file.tar.gz", false},
+		{`{{ dir .RelativePath }}`, "dir/sub", false},
+		{`{{ ext .Name }}`, ".gz", false},
+		{`{{ trimExt .Name }}`, "file.tar", false},
+		{`{{ split "/" .RelativePath | join "," }}`, "dir,sub,file.tar.gz", false},
+		{`{{ index (split "/" .RelativePath) 1 }}`, "sub", false},
+		{`{{ .RelativePath | replace "/" "-" }}`, "dir-sub-file.tar.gz", false},
+		{`{{ .Name | regexReplace "\\.tar\\.gz$" ".tgz" }}`, "file.tgz", false},
+		{`{{ .Name | regexReplace "^(\\w+)\\..*$" "${1}" }}`, "file", false},
+		{`{{ .Name | regexReplace "(" "" }}`, "", true},
+		{`{{ env "FUSEE_TEST_VAR" }}`, "value", false},
+		{`{{ env "FUSEE_TEST_UNSET_VAR" }}`, "", false},
+		{`{{ upper .MountName }} {{ lower "MiXeD" }}`, "MOUNT mixed", false},
+		{`{{ .CallerExe | default "unknown" }}`, "unknown", false},
+		{`{{ .MountName | default "unknown" }}`, "mount", false},
+		{`{{ b64enc "secret/path" }}`, "c2VjcmV0L3BhdGg=", false},
+		{`{{ b64dec "c2VjcmV0L3BhdGg=" }}`, "secret/path", false},
+		{`{{ b64dec "not base64!" }}`, "", true},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.template, func(t *testing.T) {
+			output, execErr := executeTestTemplate(t, curTest.template, state)
+			if (execErr != nil) != curTest.expectErr {
+				t.Fatalf("Expected an error: %t, got '%v'", curTest.expectErr, execErr)
+			}
+			if !curTest.expectErr && output != curTest.expected {
+				t.Errorf("Expected '%s', got '%s'", curTest.expected, output)
+			}
+		})
+	}
+}
+
+// TestShellQuote checks that quoted text reaches the shell as a single, unchanged word.
+func TestShellQuote(t *testing.T) {
+	for _, curText := range []string{
+		"plain",
+		"with space",
+		"it's",
+		`"double" and 'single'`,
+		"$(touch /tmp/fusee-should-not-exist) `id` $HOME",
+		"new\nline",
+		"*?[]{};&|<>",
+	} {
+		t.Run(curText, func(t *testing.T) {
+			output, runErr := exec.Command("sh", "-c", "printf '%s' "+shellQuote(curText)).Output()
+			if runErr != nil {
+				t.Fatalf("Unable to run sh: %v", runErr)
+			}
+			if string(output) != curText {
+				t.Errorf("Expected '%s', got '%s'", curText, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package command
+
+import (
+	"errors"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/jasonrogena/fusee/internal/pkg/metrics"
+)
+
+var executionsTotal = metrics.NewCounterVec(
+	"fusee_command_executions_total",
+	"Number of commands executed, by exit status.",
+	"mount", "kind", "exit_status")
+var executionDuration = metrics.NewHistogramVec(
+	"fusee_command_duration_seconds",
+	"Time taken by commands to run.",
+	metrics.DefaultBuckets,
+	"mount", "kind")
+var queueWait = metrics.NewHistogramVec(
+	"fusee_command_queue_wait_seconds",
+	"Time commands spent queued in a command pool before they started running.",
+	metrics.DefaultBuckets,
+	"mount", "kind")
+
+func observeExecution(c *Command, startTime time.Time, runErr error) {
+	executionsTotal.Inc(c.state.MountName, c.kind, GetExitStatus(runErr))
+	executionDuration.Observe(time.Now().Sub(startTime).Seconds(), c.state.MountName, c.kind)
+}
+
+func observeQueueWait(c *Command) {
+	if c.queuedAt.IsZero() {
+		return
+	}
+	queueWait.Observe(time.Now().Sub(c.queuedAt).Seconds(), c.state.MountName, c.kind)
+}
+
+// GetExitStatus returns the exit code of a command given the error returned when running it.
+// Returns "signal" if the command was killed by a signal, "timeout" or "interrupted" if it was
+// killed because it timed out or was interrupted, and "error" if it could not be run.
+func GetExitStatus(runErr error) string {
+	if runErr == nil {
+		return "0"
+	}
+	if errors.Is(runErr, ErrTimedOut) {
+		return "timeout"
+	}
+	if errors.Is(runErr, ErrInterrupted) {
+		return "interrupted"
+	}
+	exitCode, exited := GetExitCode(runErr)
+	if exited {
+		return strconv.Itoa(exitCode)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(runErr, &exitErr) {
+		return "signal"
+	}
+
+	return "error"
+}
@@ -1,27 +1,37 @@
 package command
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+var ErrPoolStopped = errors.New("Command pool has been stopped")
+
+// Pool runs commands using a fixed number of runners. Commands are taken from a channel shared by
+// all the runners so that each command is run by the first runner that is free.
 type Pool struct {
 	commands  chan *Command
 	kill      chan struct{}
+	killOnce  *sync.Once
 	noRunners int
 	runners   []*runner
 }
 
 func NewPool(noRunners int) *Pool {
+	commands := make(chan *Command)
+	kill := make(chan struct{})
 	runners := []*runner{}
 	for i := 0; i < noRunners; i++ {
-		runners = append(runners, newRunner(i))
+		runners = append(runners, newRunner(i, commands, kill))
 	}
 	return &Pool{
-		commands:  make(chan *Command),
-		kill:      make(chan struct{}),
+		commands:  commands,
+		kill:      kill,
+		killOnce:  new(sync.Once),
 		noRunners: noRunners,
 		runners:   runners,
 	}
@@ -33,41 +43,76 @@ func (p *Pool) Start() {
 		curRunner.start()
 	}
 	go func() {
-		lastStatTime := time.Now()
-		commandIndex := -1
+		statTicker := time.NewTicker(5 * time.Minute)
+		defer statTicker.Stop()
 		for {
 			select {
 			case <-p.kill:
 				log.Info("Killing all worker threads")
+				return
+			case <-statTicker.C:
 				for _, curRunner := range p.runners {
-					curRunner.stop()
-				}
-				break
-			default:
-				curCommand := <-p.commands
-				commandIndex++
-				// try and see if the next round-robbed worker is available
-				designatedRunner := p.runners[commandIndex%len(p.runners)]
-				designatedRunner.addCommand(curCommand)
-
-				if time.Now().Sub(lastStatTime).Minutes() > 5 {
-					for curRunnerIndex, curRunner := range p.runners {
-						log.Info(fmt.Sprintf("Worker thread %d has executed %d commands so far", curRunner.id, curRunner.gettNoCommandsRun()))
-						if curRunnerIndex == (len(p.runners) - 1) {
-							lastStatTime = time.Now()
-						}
-					}
+					log.Info(fmt.Sprintf("Worker thread %d has executed %d commands so far", curRunner.id, curRunner.gettNoCommandsRun()))
 				}
 			}
 		}
 	}()
 }
 
+// AddCommand queues the command for execution. If the pool has been stopped, the command's
+// postRunHook is called with ErrPoolStopped instead. If the command's context is canceled before
+// the command is queued, its postRunHook is called with ErrInterrupted.
 func (p *Pool) AddCommand(c *Command) {
-	p.commands <- c
+	c.queuedAt = time.Now()
+	select {
+	case p.commands <- c:
+	case <-p.kill:
+		c.abort(ErrPoolStopped)
+	case <-c.ctx.Done():
+		c.abort(ErrInterrupted)
+	}
 }
 
-func (p *Pool) Stop() {
+// Stop stops the pool from accepting new commands and waits for up to gracePeriod for the
+// commands already running to finish. Commands still running after the grace period are killed.
+// Returns the number of commands that were killed.
+func (p *Pool) Stop(gracePeriod time.Duration) int {
 	log.Debug("Stop() called on worker thread pool")
-	p.kill <- struct{}{}
+	p.killOnce.Do(func() {
+		close(p.kill)
+	})
+
+	deadline := time.Now().Add(gracePeriod)
+	for p.isRunningCommands() && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	noKilled := 0
+	for _, curRunner := range p.runners {
+		if curRunner.killCurrentCommand() {
+			noKilled++
+		}
+	}
+
+	return noKilled
+}
+
+func (p *Pool) isRunningCommands() bool {
+	for _, curRunner := range p.runners {
+		if curRunner.getIsRunningCommand() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetStats returns the stats for each of the pool's runners.
+func (p *Pool) GetStats() []RunnerStats {
+	stats := []RunnerStats{}
+	for _, curRunner := range p.runners {
+		stats = append(stats, curRunner.getStats())
+	}
+
+	return stats
 }
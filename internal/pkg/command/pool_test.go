@@ -0,0 +1,96 @@
+package command
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// addTestCommand adds a command running shell to the pool. The time the command finished at is
+// sent to finished.
+func addTestCommand(pool *Pool, shell string, finished chan<- time.Time) {
+	go pool.AddCommand(NewCommand(context.Background(), KindRead, Template{Shell: shell}, 0, NewState("mount", "/mnt", "", ""), func(output []byte, stderr []byte, commandErr error) {
+		finished <- time.Now()
+	}))
+}
+
+// TestPoolUsesFreeRunners checks that commands are not queued behind a slow command while other
+// runners are free.
+func TestPoolUsesFreeRunners(t *testing.T) {
+	pool := NewPool(2)
+	pool.Start()
+	defer pool.Stop(0)
+	slowFinished := make(chan time.Time, 1)
+	addTestCommand(pool, "sleep 2", slowFinished)
+	time.Sleep(200 * time.Millisecond)
+
+	fastFinished := make(chan time.Time, 4)
+	for i := 0; i < 4; i++ {
+		addTestCommand(pool, "true", fastFinished)
+	}
+	for i := 0; i < 4; i++ {
+		select {
+		case <-fastFinished:
+		case <-slowFinished:
+			t.Fatal("A fast command waited for the slow command to finish")
+		}
+	}
+	stats := pool.GetStats()
+	if commandsRun := stats[0].CommandsRun + stats[1].CommandsRun; commandsRun != 5 {
+		t.Errorf("Expected 5 commands to have been run, got %d", commandsRun)
+	}
+}
+
+func TestPoolRunsConcurrently(t *testing.T) {
+	tests := []struct {
+		noRunners   int
+		maxDuration time.Duration
+	}{
+		{1, 0},
+		{4, 900 * time.Millisecond},
+	}
+	for _, curTest := range tests {
+		pool := NewPool(curTest.noRunners)
+		pool.Start()
+		startTime := time.Now()
+		finished := make(chan time.Time, 4)
+		for i := 0; i < 4; i++ {
+			addTestCommand(pool, "sleep 0.3", finished)
+		}
+		for i := 0; i < 4; i++ {
+			<-finished
+		}
+		pool.Stop(0)
+		duration := time.Since(startTime)
+		if curTest.maxDuration > 0 && duration > curTest.maxDuration {
+			t.Errorf("Expected %d runners to run 4 commands in under %v, took %v", curTest.noRunners, curTest.maxDuration, duration)
+		}
+		if curTest.maxDuration == 0 && duration < 1200*time.Millisecond {
+			t.Errorf("Expected a single runner to run commands one at a time, took %v", duration)
+		}
+	}
+}
+
+func TestPoolStopped(t *testing.T) {
+	pool := NewPool(1)
+	pool.Start()
+	running := make(chan time.Time, 1)
+	addTestCommand(pool, "sleep 10", running)
+	time.Sleep(200 * time.Millisecond)
+	if noKilled := pool.Stop(0); noKilled != 1 {
+		t.Errorf("Expected the running command to be killed, %d were killed", noKilled)
+	}
+
+	var wg sync.WaitGroup
+	var addErr error
+	wg.Add(1)
+	pool.AddCommand(NewCommand(context.Background(), KindRead, Template{Shell: "true"}, 0, NewState("mount", "/mnt", "", ""), func(output []byte, stderr []byte, commandErr error) {
+		defer wg.Done()
+		addErr = commandErr
+	}))
+	wg.Wait()
+	if addErr != ErrPoolStopped {
+		t.Errorf("Expected '%v' for a command added after the pool was stopped, got '%v'", ErrPoolStopped, addErr)
+	}
+}
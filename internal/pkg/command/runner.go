@@ -11,6 +11,8 @@ import (
 type runner struct {
 	commands              chan *Command
 	kill                  chan struct{}
+	curCmd                *Command
+	curCmdMutex           *sync.Mutex
 	curCmdStartTime       time.Time
 	curCmdStartTimeMutex  *sync.Mutex
 	id                    int
@@ -20,10 +22,13 @@ type runner struct {
 	noCommandsRunMutex    *sync.Mutex
 }
 
-func newRunner(id int) *runner {
+// newRunner creates a runner that runs the commands sent to the commands channel, which can be
+// shared with other runners, until the kill channel is closed.
+func newRunner(id int, commands chan *Command, kill chan struct{}) *runner {
 	return &runner{
-		commands:              make(chan *Command),
-		kill:                  make(chan struct{}),
+		commands:              commands,
+		kill:                  kill,
+		curCmdMutex:           new(sync.Mutex),
 		curCmdStartTimeMutex:  new(sync.Mutex),
 		id:                    id,
 		isRunningCommand:      false,
@@ -33,6 +38,24 @@ func newRunner(id int) *runner {
 	}
 }
 
+func (r *runner) setCurrentCommand(c *Command) {
+	r.curCmdMutex.Lock()
+	defer r.curCmdMutex.Unlock()
+	r.curCmd = c
+}
+
+// killCurrentCommand kills the command the runner is currently running. Returns false if the
+// runner was not running any command.
+func (r *runner) killCurrentCommand() bool {
+	r.curCmdMutex.Lock()
+	defer r.curCmdMutex.Unlock()
+	if r.curCmd == nil {
+		return false
+	}
+	log.Warn(fmt.Sprintf("Killing command running in worker thread %d", r.id))
+	return r.curCmd.Kill()
+}
+
 func (r *runner) resetCurrentCommandStartTime() {
 	r.curCmdStartTimeMutex.Lock()
 	defer r.curCmdStartTimeMutex.Unlock()
@@ -72,26 +95,6 @@ func (r *runner) gettNoCommandsRun() int {
 	return noCommands
 }
 
-func (r *runner) getCapacity() int64 {
-	var timeDiff int64
-	timeDiff = 0
-	curCmdStartTime := r.getCurrentCommandStartTime()
-	if !curCmdStartTime.IsZero() {
-		timeDiff = time.Now().Sub(curCmdStartTime).Nanoseconds()
-	}
-	if timeDiff < 0 {
-		// Assume system time was changed between when the last command started running and now
-		timeDiff = 0
-		log.Warn("Current running command in goroutine appears to have started in the future. Assuming system time has been changed")
-	}
-	noCommands := len(r.commands)
-	if r.getIsRunningCommand() {
-		noCommands++
-	}
-
-	return (int64(noCommands) * timeDiff) + int64(noCommands)
-}
-
 func (r *runner) start() {
 	log.Debug(fmt.Sprintf("start() called on worker thread %d", r.id))
 	go func() {
@@ -99,29 +102,43 @@ func (r *runner) start() {
 			select {
 			case <-r.kill:
 				log.Info(fmt.Sprintf("Stopping execution of worker thread %d", r.id))
-				break
-			default:
-				curCommand := <-r.commands
+				return
+			case curCommand := <-r.commands:
+				observeQueueWait(curCommand)
 				r.setIsRunningCommand(true)
 				r.incrementNoCommandsRun()
 				r.resetCurrentCommandStartTime()
+				r.setCurrentCommand(curCommand)
 				curCommand.Run()
+				r.setCurrentCommand(nil)
 				r.setIsRunningCommand(false)
 			}
 		}
 	}()
 }
 
-func (r *runner) stop() {
-	log.Debug(fmt.Sprintf("stop() called on worker thread %d", r.id))
-	r.kill <- struct{}{}
+func (r *runner) getID() int {
+	return r.id
 }
 
-func (r *runner) addCommand(c *Command) {
-	log.Debug(fmt.Sprintf("addCommand called for worker thread %d", r.id))
-	r.commands <- c
+// RunnerStats describes the work done by one of a pool's runners.
+type RunnerStats struct {
+	ID               int  `json:"id"`
+	CommandsRun      int  `json:"commandsRun"`
+	IsRunningCommand bool `json:"isRunningCommand"`
+	// How long the command currently running has been running for. Is 0 if no command is running
+	CurrentCommandSeconds float64 `json:"currentCommandSeconds"`
 }
 
-func (r *runner) getID() int {
-	return r.id
+func (r *runner) getStats() RunnerStats {
+	stats := RunnerStats{
+		ID:               r.id,
+		CommandsRun:      r.gettNoCommandsRun(),
+		IsRunningCommand: r.getIsRunningCommand(),
+	}
+	if stats.IsRunningCommand {
+		stats.CurrentCommandSeconds = time.Now().Sub(r.getCurrentCommandStartTime()).Seconds()
+	}
+
+	return stats
 }
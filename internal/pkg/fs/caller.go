@@ -0,0 +1,62 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/jasonrogena/fusee/internal/pkg/command"
+)
+
+// WithCaller returns a copy of commandState with the identity of the process that made the FUSE
+// request in ctx. If ctx is not for a FUSE request, the copy does not have a caller.
+func WithCaller(ctx context.Context, commandState *command.State) *command.State {
+	callerState := command.CopyState(commandState)
+	caller, hasCaller := fuse.FromContext(ctx)
+	if !hasCaller || caller.Pid == 0 {
+		return callerState
+	}
+	callerState.CallerUID = caller.Uid
+	callerState.CallerGID = caller.Gid
+	callerState.CallerPID = caller.Pid
+	callerState.CallerComm = GetProcessName(caller.Pid)
+	callerState.CallerExe = GetProcessExe(caller.Pid)
+
+	return callerState
+}
+
+// GetProcessName returns the name of the process with the provided ID, as shown in
+// /proc/<pid>/comm. Returns a blank string if the name cannot be read, for instance because the
+// process has exited.
+func GetProcessName(pid uint32) string {
+	comm, readErr := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if readErr != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(comm))
+}
+
+// GetProcessExe returns the path of the executable of the process with the provided ID. Returns
+// a blank string if the path cannot be read.
+func GetProcessExe(pid uint32) string {
+	exe, readErr := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if readErr != nil {
+		return ""
+	}
+
+	return exe
+}
+
+// GetProcessCmdline returns the command line of the process with the provided ID, with its
+// arguments separated by spaces. Returns a blank string if the command line cannot be read.
+func GetProcessCmdline(pid uint32) string {
+	cmdline, readErr := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if readErr != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+}
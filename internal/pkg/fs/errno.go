@@ -0,0 +1,37 @@
+package fs
+
+import (
+	"fmt"
+	"syscall"
+)
+
+var errnos = map[string]syscall.Errno{
+	"EPERM":        syscall.EPERM,
+	"ENOENT":       syscall.ENOENT,
+	"EINTR":        syscall.EINTR,
+	"EIO":          syscall.EIO,
+	"ENXIO":        syscall.ENXIO,
+	"EAGAIN":       syscall.EAGAIN,
+	"EACCES":       syscall.EACCES,
+	"EBUSY":        syscall.EBUSY,
+	"ENOTDIR":      syscall.ENOTDIR,
+	"EISDIR":       syscall.EISDIR,
+	"EINVAL":       syscall.EINVAL,
+	"ENOSPC":       syscall.ENOSPC,
+	"EROFS":        syscall.EROFS,
+	"ENOSYS":       syscall.ENOSYS,
+	"ENODATA":      syscall.ENODATA,
+	"ETIMEDOUT":    syscall.ETIMEDOUT,
+	"ECONNREFUSED": syscall.ECONNREFUSED,
+	"EHOSTDOWN":    syscall.EHOSTDOWN,
+}
+
+// ParseErrno returns the errno with the provided name, for example "ENOENT".
+func ParseErrno(name string) (syscall.Errno, error) {
+	errno, found := errnos[name]
+	if !found {
+		return 0, fmt.Errorf("'%s' is not a supported errno", name)
+	}
+
+	return errno, nil
+}
@@ -0,0 +1,32 @@
+package fs
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseErrno(t *testing.T) {
+	tests := []struct {
+		name      string
+		expected  syscall.Errno
+		expectErr bool
+	}{
+		{"ENOENT", syscall.ENOENT, false},
+		{"EACCES", syscall.EACCES, false},
+		{"ETIMEDOUT", syscall.ETIMEDOUT, false},
+		{"enoent", 0, true},
+		{"EWHATEVER", 0, true},
+		{"", 0, true},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			errno, parseErr := ParseErrno(curTest.name)
+			if (parseErr != nil) != curTest.expectErr {
+				t.Fatalf("Expected an error: %t, got '%v'", curTest.expectErr, parseErr)
+			}
+			if errno != curTest.expected {
+				t.Errorf("Expected %v, got %v", curTest.expected, errno)
+			}
+		})
+	}
+}
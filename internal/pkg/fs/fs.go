@@ -27,3 +27,10 @@ func GetFileStableAttr(commandState *command.State) fs.StableAttr {
 		Mode: syscall.S_IFREG,
 	}
 }
+
+func GetSymlinkStableAttr(commandState *command.State) fs.StableAttr {
+	return fs.StableAttr{
+		Ino:  generateInodeNumber(commandState.MountRootDirPath + commandState.RelativePath),
+		Mode: syscall.S_IFLNK,
+	}
+}
@@ -0,0 +1,224 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram buckets, in seconds, used for timing operations.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry holds a set of metrics and writes them in the Prometheus text exposition format.
+type Registry struct {
+	collectors []collector
+	mutex      *sync.Mutex
+}
+
+// DefaultRegistry is the registry metrics created using NewCounterVec and NewHistogramVec are
+// registered in.
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: []collector{},
+		mutex:      new(sync.Mutex),
+	}
+}
+
+func (r *Registry) register(c collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Write writes all the metrics in the registry to w.
+func (r *Registry) Write(w io.Writer) error {
+	r.mutex.Lock()
+	collectors := append([]collector{}, r.collectors...)
+	r.mutex.Unlock()
+
+	bufferedWriter := bufio.NewWriter(w)
+	for _, curCollector := range collectors {
+		curCollector.write(bufferedWriter)
+	}
+	return bufferedWriter.Flush()
+}
+
+// Handler returns an HTTP handler that serves the metrics in the registry.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// Only used by histograms
+	bucketCounts []uint64
+	count        uint64
+}
+
+type vec struct {
+	name       string
+	help       string
+	labelNames []string
+	series     map[string]*series
+	mutex      *sync.Mutex
+}
+
+func newVec(name string, help string, labelNames []string) vec {
+	return vec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		series:     map[string]*series{},
+		mutex:      new(sync.Mutex),
+	}
+}
+
+// getSeries returns the series for the label values. The vec's mutex should be held by the caller.
+func (v *vec) getSeries(labelValues []string, noBuckets int) *series {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("Metric %s expects %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, found := v.series[key]
+	if !found {
+		s = &series{
+			labelValues:  append([]string{}, labelValues...),
+			bucketCounts: make([]uint64, noBuckets),
+		}
+		v.series[key] = s
+	}
+
+	return s
+}
+
+// sortedSeries returns the vec's series sorted by their label values. The vec's mutex should be
+// held by the caller.
+func (v *vec) sortedSeries() []*series {
+	keys := []string{}
+	for curKey := range v.series {
+		keys = append(keys, curKey)
+	}
+	sort.Strings(keys)
+	sorted := []*series{}
+	for _, curKey := range keys {
+		sorted = append(sorted, v.series[curKey])
+	}
+
+	return sorted
+}
+
+func (v *vec) formatLabels(labelValues []string, extraName string, extraValue string) string {
+	pairs := []string{}
+	for curIndex, curName := range v.labelNames {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, curName, labelValueEscaper.Replace(labelValues[curIndex])))
+	}
+	if len(extraName) > 0 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, labelValueEscaper.Replace(extraValue)))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// CounterVec is a set of counters partitioned by label values.
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec creates a counter and registers it in DefaultRegistry.
+func NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(name, help, labelNames)}
+	DefaultRegistry.register(c)
+	return c
+}
+
+// Add adds value to the counter with the provided label values.
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.getSeries(labelValues, 0).value += value
+}
+
+// Inc increments the counter with the provided label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, helpEscaper.Replace(c.help), c.name)
+	for _, curSeries := range c.sortedSeries() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.formatLabels(curSeries.labelValues, "", ""), formatFloat(curSeries.value))
+	}
+}
+
+// HistogramVec is a set of histograms partitioned by label values.
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// NewHistogramVec creates a histogram with the provided bucket upper bounds, sorted in increasing
+// order, and registers it in DefaultRegistry.
+func NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	h := &HistogramVec{vec: newVec(name, help, labelNames), buckets: buckets}
+	DefaultRegistry.register(h)
+	return h
+}
+
+// Observe records value in the histogram with the provided label values.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s := h.getSeries(labelValues, len(h.buckets))
+	for curIndex, curBound := range h.buckets {
+		if value <= curBound {
+			s.bucketCounts[curIndex]++
+		}
+	}
+	s.count++
+	s.value += value
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, helpEscaper.Replace(h.help), h.name)
+	for _, curSeries := range h.sortedSeries() {
+		for curIndex, curBound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.formatLabels(curSeries.labelValues, "le", formatFloat(curBound)), curSeries.bucketCounts[curIndex])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.formatLabels(curSeries.labelValues, "le", "+Inf"), curSeries.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.formatLabels(curSeries.labelValues, "", ""), formatFloat(curSeries.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.formatLabels(curSeries.labelValues, "", ""), curSeries.count)
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestCounterVec(r *Registry, name string, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(name, help, labelNames)}
+	r.register(c)
+	return c
+}
+
+func newTestHistogramVec(r *Registry, name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	h := &HistogramVec{vec: newVec(name, help, labelNames), buckets: buckets}
+	r.register(h)
+	return h
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		record   func(r *Registry)
+		expected string
+	}{
+		{
+			name: "counter without labels",
+			record: func(r *Registry) {
+				c := newTestCounterVec(r, "requests_total", "The number of requests.")
+				c.Inc()
+				c.Add(1.5)
+			},
+			expected: `# HELP requests_total The number of requests.
+# TYPE requests_total counter
+requests_total 2.5
+`,
+		},
+		{
+			name: "counter series sorted by label values",
+			record: func(r *Registry) {
+				c := newTestCounterVec(r, "ops_total", "Ops.", "mount", "op")
+				c.Inc("b", "Read")
+				c.Inc("a", "Open")
+				c.Inc("a", "Open")
+			},
+			expected: `# HELP ops_total Ops.
+# TYPE ops_total counter
+ops_total{mount="a",op="Open"} 2
+ops_total{mount="b",op="Read"} 1
+`,
+		},
+		{
+			name: "label values and help escaped",
+			record: func(r *Registry) {
+				c := newTestCounterVec(r, "escaped_total", "Back\\slash and\nnewline.", "path")
+				c.Inc("a\\b\"c\nd")
+			},
+			expected: `# HELP escaped_total Back\\slash and\nnewline.
+# TYPE escaped_total counter
+escaped_total{path="a\\b\"c\nd"} 1
+`,
+		},
+		{
+			name: "histogram buckets, sum and count",
+			record: func(r *Registry) {
+				h := newTestHistogramVec(r, "duration_seconds", "Durations.", []float64{0.5, 1, 2.5}, "op")
+				h.Observe(0.25, "Read")
+				h.Observe(1, "Read")
+				h.Observe(10, "Read")
+			},
+			expected: `# HELP duration_seconds Durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{op="Read",le="0.5"} 1
+duration_seconds_bucket{op="Read",le="1"} 2
+duration_seconds_bucket{op="Read",le="2.5"} 2
+duration_seconds_bucket{op="Read",le="+Inf"} 3
+duration_seconds_sum{op="Read"} 11.25
+duration_seconds_count{op="Read"} 3
+`,
+		},
+		{
+			name: "histogram without labels",
+			record: func(r *Registry) {
+				h := newTestHistogramVec(r, "size_bytes", "Sizes.", []float64{10})
+				h.Observe(math.Inf(1))
+			},
+			expected: `# HELP size_bytes Sizes.
+# TYPE size_bytes histogram
+size_bytes_bucket{le="10"} 0
+size_bytes_bucket{le="+Inf"} 1
+size_bytes_sum +Inf
+size_bytes_count 1
+`,
+		},
+		{
+			name: "metrics without series",
+			record: func(r *Registry) {
+				newTestCounterVec(r, "a_total", "A.", "mount")
+				newTestHistogramVec(r, "b_seconds", "B.", DefaultBuckets, "mount")
+			},
+			expected: `# HELP a_total A.
+# TYPE a_total counter
+# HELP b_seconds B.
+# TYPE b_seconds histogram
+`,
+		},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			r := NewRegistry()
+			curTest.record(r)
+			output := &bytes.Buffer{}
+			if writeErr := r.Write(output); writeErr != nil {
+				t.Fatalf("Write failed: %v", writeErr)
+			}
+			if output.String() != curTest.expected {
+				t.Errorf("Unexpected output.\nExpected:\n%s\nGot:\n%s", curTest.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestWrongNumberOfLabelValues(t *testing.T) {
+	c := newTestCounterVec(NewRegistry(), "ops_total", "Ops.", "mount")
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic when the wrong number of label values is given")
+		}
+	}()
+	c.Inc("a", "b")
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	newTestCounterVec(r, "requests_total", "Requests.").Inc()
+	recorder := httptest.NewRecorder()
+	r.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Unexpected content type '%s'", contentType)
+	}
+	if !bytes.Contains(recorder.Body.Bytes(), []byte("requests_total 1\n")) {
+		t.Errorf("Unexpected body:\n%s", recorder.Body.String())
+	}
+}
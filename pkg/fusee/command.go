@@ -0,0 +1,113 @@
+package fusee
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/jasonrogena/fusee/internal/app/fusee/mount"
+	"github.com/jasonrogena/fusee/internal/pkg/command"
+)
+
+// Commands are the shell commands a command provider runs. Each command is a Go template, filled
+// in with the same variables and functions as the readCommand of a mount in fusee's configuration
+// file, and is run using sh -c.
+type Commands struct {
+	// Prints the names of the entries in the directory at .RelativePath, separated by
+	// NameSeparator
+	List          string
+	NameSeparator string
+	// Exits with 0 if the entry at .RelativePath is a directory. Every entry is a file if blank
+	Stat string
+	// Prints the contents of the file at .RelativePath
+	Read string
+	// The name and path of the mount, set as .MountName and .MountRootDirPath
+	MountName string
+	MountPath string
+}
+
+// commandProvider is a Provider that runs shell commands, the way the mounts in fusee's
+// configuration file do.
+type commandProvider struct {
+	commands Commands
+	list     command.Template
+	stat     command.Template
+	read     command.Template
+}
+
+// NewCommandProvider returns a Provider that runs the commands. Returns an error if any of the
+// commands cannot be parsed.
+func NewCommandProvider(commands Commands) (Provider, error) {
+	if len(commands.List) == 0 || len(commands.NameSeparator) == 0 || len(commands.Read) == 0 {
+		return nil, errors.New("The list and read commands, and the name separator, are required")
+	}
+	p := &commandProvider{commands: commands}
+	for _, curCommand := range []struct {
+		name     string
+		text     string
+		template *command.Template
+	}{
+		{"list", commands.List, &p.list},
+		{"stat", commands.Stat, &p.stat},
+		{"read", commands.Read, &p.read},
+	} {
+		parsed, parseErr := command.Template{Shell: curCommand.text}.Parse()
+		if parseErr != nil {
+			return nil, fmt.Errorf("Unable to parse the %s command: %w", curCommand.name, parseErr)
+		}
+		*curCommand.template = parsed
+	}
+
+	return p, nil
+}
+
+func (p *commandProvider) List(ctx context.Context, relativePath string) ([]string, error) {
+	output, runErr := p.run(ctx, command.KindList, p.list, relativePath)
+	if runErr != nil {
+		return nil, runErr
+	}
+
+	return mount.ParseNames(output, p.commands.NameSeparator), nil
+}
+
+func (p *commandProvider) Stat(ctx context.Context, relativePath string) (Info, error) {
+	if len(p.commands.Stat) == 0 {
+		return Info{}, nil
+	}
+	_, runErr := p.run(ctx, command.KindProbe, p.stat, relativePath)
+	isDir, probeErr := mount.IsDirectoryProbe(runErr)
+	if probeErr != nil {
+		return Info{}, probeErr
+	}
+
+	return Info{IsDir: isDir}, nil
+}
+
+func (p *commandProvider) Read(ctx context.Context, relativePath string) ([]byte, error) {
+	return p.run(ctx, command.KindRead, p.read, relativePath)
+}
+
+// run runs the command for the entry at relativePath, returning its output. The error returned if
+// the command fails contains what the command wrote to its stderr.
+func (p *commandProvider) run(ctx context.Context, kind string, template command.Template, relativePath string) ([]byte, error) {
+	name := ""
+	if len(relativePath) > 0 {
+		name = path.Base(relativePath)
+	}
+	state := command.NewState(p.commands.MountName, p.commands.MountPath, relativePath, name)
+	var output []byte
+	var runErr error
+	command.NewCommand(ctx, kind, template, 0, state, func(commandOutput []byte, stderr []byte, commandErr error) {
+		output = commandOutput
+		runErr = commandErr
+		if trimmedStderr := bytes.TrimSpace(stderr); commandErr != nil && len(trimmedStderr) > 0 {
+			runErr = fmt.Errorf("%w: %s", commandErr, trimmedStderr)
+		}
+	}).Run()
+
+	return output, runErr
+}
+
+var _ = (Provider)((*commandProvider)(nil))
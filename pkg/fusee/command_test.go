@@ -0,0 +1,81 @@
+package fusee
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNewCommandProvider(t *testing.T) {
+	tests := []struct {
+		name      string
+		commands  Commands
+		expectErr bool
+	}{
+		{"valid", Commands{List: "ls", NameSeparator: "\n", Read: "cat {{ .RelativePath }}"}, false},
+		{"with stat", Commands{List: "ls", NameSeparator: "\n", Stat: "test -d {{ .RelativePath }}", Read: "cat"}, false},
+		{"no list", Commands{NameSeparator: "\n", Read: "cat"}, true},
+		{"no name separator", Commands{List: "ls", Read: "cat"}, true},
+		{"no read", Commands{List: "ls", NameSeparator: "\n"}, true},
+		{"invalid template", Commands{List: "ls {{ .RelativePath", NameSeparator: "\n", Read: "cat"}, true},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			if _, newErr := NewCommandProvider(curTest.commands); (newErr != nil) != curTest.expectErr {
+				t.Errorf("Expected an error: %t, got '%v'", curTest.expectErr, newErr)
+			}
+		})
+	}
+}
+
+func TestCommandProvider(t *testing.T) {
+	provider, newErr := NewCommandProvider(Commands{
+		List:          `printf 'a, b ,,c' # {{ .RelativePath }}`,
+		NameSeparator: ",",
+		Stat:          `case {{ shellquote .RelativePath }} in dir*) exit 0;; missing) echo gone >&2; exit 2;; *) exit 1;; esac`,
+		Read:          `if [ {{ shellquote .Name }} = missing ]; then echo 'no such secret' >&2; exit 2; fi; printf '%s in %s' {{ shellquote .RelativePath }} {{ .MountName }}`,
+		MountName:     "secrets",
+		MountPath:     "/mnt/secrets",
+	})
+	if newErr != nil {
+		t.Fatal(newErr)
+	}
+	ctx := context.Background()
+
+	names, listErr := provider.List(ctx, "")
+	if listErr != nil || strings.Join(names, "|") != "a|b|c" {
+		t.Errorf("Expected the names a, b and c, got %q, '%v'", names, listErr)
+	}
+	for _, curTest := range []struct {
+		path      string
+		isDir     bool
+		expectErr bool
+	}{
+		{"dir", true, false},
+		{"dir/sub", true, false},
+		{"file", false, false},
+		{"missing", false, false},
+	} {
+		info, statErr := provider.Stat(ctx, curTest.path)
+		if (statErr != nil) != curTest.expectErr || info.IsDir != curTest.isDir {
+			t.Errorf("Expected '%s' to be a directory: %t, got %t, '%v'", curTest.path, curTest.isDir, info.IsDir, statErr)
+		}
+	}
+	content, readErr := provider.Read(ctx, "dir/it's")
+	if readErr != nil || string(content) != "dir/it's in secrets" {
+		t.Errorf("Expected the file's content, got '%s', '%v'", content, readErr)
+	}
+	if _, readErr := provider.Read(ctx, "dir/missing"); readErr == nil || !strings.Contains(readErr.Error(), "no such secret") {
+		t.Errorf("Expected an error containing the command's stderr, got '%v'", readErr)
+	}
+}
+
+func TestCommandProviderWithoutStat(t *testing.T) {
+	provider, newErr := NewCommandProvider(Commands{List: "true", NameSeparator: "\n", Read: "true"})
+	if newErr != nil {
+		t.Fatal(newErr)
+	}
+	if info, statErr := provider.Stat(context.Background(), "dir"); statErr != nil || info.IsDir {
+		t.Errorf("Expected every entry to be a file, got %t, '%v'", info.IsDir, statErr)
+	}
+}
@@ -0,0 +1,145 @@
+package fusee
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/jasonrogena/fusee/internal/app/fusee/config"
+	"github.com/jasonrogena/fusee/internal/app/fusee/mount"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultDirMode = 0o555
+const defaultFileMode = 0o444
+
+// Options configures a mount created using Mount.
+type Options struct {
+	// The name of the mount in logs and metrics. Defaults to the base name of the mount's path
+	Name string
+	// The permissions of the directories and files in the mount. Default to 0o555 and 0o444
+	DirMode  uint32
+	FileMode uint32
+	// The number of seconds directory listings and file contents are cached for. Nothing is cached
+	// if 0
+	CacheSeconds uint64
+	// The number of seconds each call to the provider is allowed to take before its context is
+	// canceled. Calls are not limited if 0
+	TimeoutSeconds float64
+	// The number of calls to List and Read that can run at once. Defaults to the number of CPUs
+	Concurrency uint
+	// Whether users other than the one that mounted the tree can access it
+	AllowOther bool
+	// The name of the filesystem shown in the mount table. Defaults to "fusee"
+	FsName string
+	Debug  bool
+	// Whether what the Provider returns depends on the caller. File contents are then cached
+	// separately for each user and group, and directory listings are not cached
+	PerCaller bool
+}
+
+// mounter is implemented by the root of a mount.
+type mounter interface {
+	Mount(debug bool) (*fuse.Server, error)
+	Unmount(deadline time.Time) error
+	StopCommands(gracePeriod time.Duration) int
+}
+
+// Server is a mounted tree.
+type Server struct {
+	root       mounter
+	fuseServer *fuse.Server
+	ended      chan struct{}
+	endedOnce  *sync.Once
+}
+
+// Mount mounts the tree provided by provider on path, which should be an empty directory. The call
+// does not block. The tree is unmounted when ctx is canceled or Unmount is called. Call Wait to
+// block until the mount ends.
+func Mount(ctx context.Context, path string, provider Provider, opts Options) (*Server, error) {
+	name := opts.Name
+	if len(name) == 0 {
+		name = filepath.Base(path)
+	}
+	root, rootErr := mount.NewRootWithBackend(name, getMountConfig(path, opts), &providerBackend{provider: provider})
+	if rootErr != nil {
+		return nil, rootErr
+	}
+	fuseServer, mountErr := root.Mount(opts.Debug)
+	if mountErr != nil {
+		return nil, fmt.Errorf("Unable to mount '%s' on '%s': %w", name, path, mountErr)
+	}
+	s := &Server{
+		root:       root,
+		fuseServer: fuseServer,
+		ended:      make(chan struct{}),
+		endedOnce:  new(sync.Once),
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			if unmountErr := s.Unmount(); unmountErr != nil {
+				log.Warn(unmountErr.Error())
+			}
+		case <-s.ended:
+		}
+	}()
+
+	return s, nil
+}
+
+// getMountConfig returns the configuration of a mount on path with the provided options.
+func getMountConfig(path string, opts Options) config.Mount {
+	dirMode := opts.DirMode
+	if dirMode == 0 {
+		dirMode = defaultDirMode
+	}
+	fileMode := opts.FileMode
+	if fileMode == 0 {
+		fileMode = defaultFileMode
+	}
+	shouldCache := opts.CacheSeconds > 0
+
+	return config.Mount{
+		Path:           path,
+		Mode:           dirMode,
+		ThreadCount:    opts.Concurrency,
+		Cache:          shouldCache,
+		CacheSeconds:   opts.CacheSeconds,
+		PerCaller:      opts.PerCaller,
+		TimeoutSeconds: opts.TimeoutSeconds,
+		Directory: config.Directory{
+			Mode:           dirMode,
+			Cache:          shouldCache,
+			CacheSeconds:   opts.CacheSeconds,
+			PerCaller:      opts.PerCaller,
+			TimeoutSeconds: opts.TimeoutSeconds,
+		},
+		File: config.File{
+			Mode:           fileMode,
+			Cache:          shouldCache,
+			CacheSeconds:   opts.CacheSeconds,
+			PerCaller:      opts.PerCaller,
+			TimeoutSeconds: opts.TimeoutSeconds,
+		},
+		AllowOther: opts.AllowOther,
+		FsName:     opts.FsName,
+	}
+}
+
+// Unmount unmounts the tree. Unmounting fails if any process is still using the mount.
+func (s *Server) Unmount() error {
+	return s.root.Unmount(time.Now())
+}
+
+// Wait blocks until the mount ends, and then cancels the calls to the provider still running.
+func (s *Server) Wait() {
+	s.fuseServer.Wait()
+	s.endedOnce.Do(func() {
+		close(s.ended)
+		s.root.StopCommands(0)
+	})
+}
@@ -0,0 +1,126 @@
+// Package fusee mounts trees whose directory listings and file contents come from a Provider,
+// reusing the caching and FUSE node logic of the fusee daemon.
+package fusee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/jasonrogena/fusee/internal/pkg/command"
+)
+
+// Provider provides the entries of a mounted tree. Paths are relative to the mount's root, with
+// the root itself being a blank path, and use "/" as the separator. Calls can be made
+// concurrently.
+//
+// Errors that are, or wrap, a syscall.Errno fail the FUSE operation with that errno. Errors for
+// which errors.Is(err, os.ErrNotExist) or errors.Is(err, os.ErrPermission) is true fail it with
+// ENOENT or EACCES. Other errors fail it with EIO.
+type Provider interface {
+	// List returns the names of the entries in the directory at path
+	List(ctx context.Context, path string) ([]string, error)
+	// Stat returns whether the entry at path is a directory or a file. If Stat fails, the entry is
+	// shown as a file
+	Stat(ctx context.Context, path string) (Info, error)
+	// Read returns the contents of the file at path
+	Read(ctx context.Context, path string) ([]byte, error)
+}
+
+// Info describes an entry in a mounted tree.
+type Info struct {
+	IsDir bool
+}
+
+// Caller is the process whose FUSE request led to a call to a Provider.
+type Caller struct {
+	UID  uint32
+	GID  uint32
+	PID  uint32
+	Comm string
+	Exe  string
+}
+
+type callerContextKey struct{}
+
+// CallerFromContext returns the process whose FUSE request led to the call to a Provider that ctx
+// was passed to. Returns false if the call was not made for a process, for instance because the
+// entry was being loaded ahead of time, or if Options.PerCaller is not set, since what the
+// Provider returns is then shared by all callers.
+func CallerFromContext(ctx context.Context) (Caller, bool) {
+	caller, hasCaller := ctx.Value(callerContextKey{}).(Caller)
+	return caller, hasCaller
+}
+
+// providerBackend sends the commands of a mount to a Provider.
+type providerBackend struct {
+	provider Provider
+}
+
+func (p *providerBackend) Run(ctx context.Context, kind string, state *command.State) ([]byte, []byte, error) {
+	if state.CallerPID != 0 {
+		ctx = context.WithValue(ctx, callerContextKey{}, Caller{
+			UID:  state.CallerUID,
+			GID:  state.CallerGID,
+			PID:  state.CallerPID,
+			Comm: state.CallerComm,
+			Exe:  state.CallerExe,
+		})
+	}
+	path := state.RelativePath
+	switch kind {
+	case command.KindList:
+		names, listErr := p.provider.List(ctx, path)
+		if listErr != nil {
+			return []byte{}, []byte{}, getBackendError(listErr)
+		}
+		return []byte(strings.Join(names, command.BackendNameSeparator)), []byte{}, nil
+	case command.KindProbe:
+		info, statErr := p.provider.Stat(ctx, path)
+		if statErr != nil {
+			return []byte{}, []byte{}, getBackendError(statErr)
+		}
+		if !info.IsDir {
+			// Probe commands that fail are for files
+			return []byte{}, []byte{}, &command.BackendError{ExitCode: 1}
+		}
+		return []byte{}, []byte{}, nil
+	case command.KindRead:
+		content, readErr := p.provider.Read(ctx, path)
+		if readErr != nil {
+			return []byte{}, []byte{}, getBackendError(readErr)
+		}
+		return content, []byte{}, nil
+	}
+
+	return []byte{}, []byte{}, fmt.Errorf("Unknown kind of command '%s'", kind)
+}
+
+func (p *providerBackend) Describe(kind string, state *command.State) string {
+	return "provider " + kind + " " + state.RelativePath
+}
+
+// getBackendError converts an error returned by a Provider into the error returned by a backend.
+func getBackendError(providerErr error) error {
+	if errors.Is(providerErr, context.Canceled) || errors.Is(providerErr, context.DeadlineExceeded) {
+		// Converted to command.ErrInterrupted or command.ErrTimedOut when the command finishes
+		return providerErr
+	}
+	backendErr := &command.BackendError{ExitCode: 1, Message: providerErr.Error()}
+	if exitCode, exited := command.GetExitCode(providerErr); exited {
+		backendErr.ExitCode = exitCode
+	}
+	var errno syscall.Errno
+	if errors.As(providerErr, &errno) {
+		backendErr.Errno = errno
+	} else if errors.Is(providerErr, os.ErrNotExist) {
+		backendErr.Errno = syscall.ENOENT
+	} else if errors.Is(providerErr, os.ErrPermission) {
+		backendErr.Errno = syscall.EACCES
+	}
+
+	return backendErr
+}
@@ -0,0 +1,163 @@
+package fusee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/jasonrogena/fusee/internal/pkg/command"
+)
+
+func TestGetBackendError(t *testing.T) {
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	tests := []struct {
+		name             string
+		err              error
+		expectedExitCode int
+		expectedErrno    syscall.Errno
+	}{
+		{"other error", errors.New("Unable to reach the API"), 1, 0},
+		{"errno", syscall.ENOTDIR, 1, syscall.ENOTDIR},
+		{"wrapped errno", fmt.Errorf("Unable to list: %w", syscall.EROFS), 1, syscall.EROFS},
+		{"not exist", fs.ErrNotExist, 1, syscall.ENOENT},
+		{"path error", &fs.PathError{Op: "open", Path: "secret", Err: os.ErrNotExist}, 1, syscall.ENOENT},
+		{"permission", fmt.Errorf("Forbidden: %w", os.ErrPermission), 1, syscall.EACCES},
+		{"exit code", exitErr, 3, 0},
+		{"backend error", &command.BackendError{ExitCode: 2}, 2, 0},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			var backendErr *command.BackendError
+			if !errors.As(getBackendError(curTest.err), &backendErr) {
+				t.Fatalf("Expected a backend error for '%v'", curTest.err)
+			}
+			if backendErr.ExitCode != curTest.expectedExitCode || backendErr.Errno != curTest.expectedErrno {
+				t.Errorf("Expected %d, %v, got %d, %v", curTest.expectedExitCode, curTest.expectedErrno, backendErr.ExitCode, backendErr.Errno)
+			}
+			if backendErr.Message != curTest.err.Error() {
+				t.Errorf("Expected the message '%s', got '%s'", curTest.err.Error(), backendErr.Message)
+			}
+		})
+	}
+}
+
+func TestGetBackendErrorInterrupted(t *testing.T) {
+	for _, curErr := range []error{context.Canceled, fmt.Errorf("Gave up: %w", context.DeadlineExceeded)} {
+		if backendErr := getBackendError(curErr); backendErr != curErr {
+			t.Errorf("Expected '%v' to be returned as is, got '%v'", curErr, backendErr)
+		}
+	}
+}
+
+// testProvider provides a tree with the directory "dir", containing the file "dir/file".
+type testProvider struct {
+	callers []Caller
+}
+
+func (p *testProvider) List(ctx context.Context, path string) ([]string, error) {
+	p.recordCaller(ctx)
+	if path != "" {
+		return nil, syscall.ENOTDIR
+	}
+	return []string{"dir", "dir file"}, nil
+}
+
+func (p *testProvider) Stat(ctx context.Context, path string) (Info, error) {
+	p.recordCaller(ctx)
+	if path == "missing" {
+		return Info{}, os.ErrNotExist
+	}
+	return Info{IsDir: path == "dir"}, nil
+}
+
+func (p *testProvider) Read(ctx context.Context, path string) ([]byte, error) {
+	p.recordCaller(ctx)
+	return []byte("content of " + path), nil
+}
+
+func (p *testProvider) recordCaller(ctx context.Context) {
+	if caller, hasCaller := CallerFromContext(ctx); hasCaller {
+		p.callers = append(p.callers, caller)
+	}
+}
+
+func TestProviderBackend(t *testing.T) {
+	tests := []struct {
+		name           string
+		kind           string
+		path           string
+		expectedOutput string
+		expectedErrno  syscall.Errno
+		expectErr      bool
+	}{
+		{"list", command.KindList, "", "dir\x00dir file", 0, false},
+		{"list failure", command.KindList, "dir/file", "", syscall.ENOTDIR, true},
+		{"stat directory", command.KindProbe, "dir", "", 0, false},
+		{"stat file", command.KindProbe, "dir/file", "", 0, true},
+		{"stat failure", command.KindProbe, "missing", "", syscall.ENOENT, true},
+		{"read", command.KindRead, "dir/file", "content of dir/file", 0, false},
+		{"unknown kind", command.KindType, "dir", "", 0, true},
+	}
+	for _, curTest := range tests {
+		t.Run(curTest.name, func(t *testing.T) {
+			backend := &providerBackend{provider: &testProvider{}}
+			output, _, runErr := backend.Run(context.Background(), curTest.kind, command.NewState("mount", "/mnt", curTest.path, curTest.path))
+			if (runErr != nil) != curTest.expectErr {
+				t.Fatalf("Expected an error: %t, got '%v'", curTest.expectErr, runErr)
+			}
+			if string(output) != curTest.expectedOutput {
+				t.Errorf("Expected '%q', got '%q'", curTest.expectedOutput, output)
+			}
+			var backendErr *command.BackendError
+			if errors.As(runErr, &backendErr) && backendErr.Errno != curTest.expectedErrno {
+				t.Errorf("Expected %v, got %v", curTest.expectedErrno, backendErr.Errno)
+			}
+		})
+	}
+}
+
+func TestCallerFromContext(t *testing.T) {
+	provider := &testProvider{}
+	backend := &providerBackend{provider: provider}
+	state := command.NewState("mount", "/mnt", "dir/file", "file")
+	if _, _, runErr := backend.Run(context.Background(), command.KindRead, state); runErr != nil {
+		t.Fatal(runErr)
+	}
+	if len(provider.callers) != 0 {
+		t.Errorf("Expected no caller for a state without one, got %v", provider.callers)
+	}
+
+	state.CallerUID = 1000
+	state.CallerGID = 100
+	state.CallerPID = 42
+	state.CallerComm = "cat"
+	state.CallerExe = "/usr/bin/cat"
+	if _, _, runErr := backend.Run(context.Background(), command.KindRead, state); runErr != nil {
+		t.Fatal(runErr)
+	}
+	expected := Caller{UID: 1000, GID: 100, PID: 42, Comm: "cat", Exe: "/usr/bin/cat"}
+	if len(provider.callers) != 1 || provider.callers[0] != expected {
+		t.Errorf("Expected the caller %v, got %v", expected, provider.callers)
+	}
+}
+
+func TestGetMountConfig(t *testing.T) {
+	conf := getMountConfig("/mnt/tree", Options{CacheSeconds: 10, PerCaller: true})
+	if conf.Mode != defaultDirMode || conf.Directory.Mode != defaultDirMode || conf.File.Mode != defaultFileMode {
+		t.Errorf("Expected the default modes, got %o, %o, %o", conf.Mode, conf.Directory.Mode, conf.File.Mode)
+	}
+	if !conf.Cache || !conf.Directory.Cache || !conf.File.Cache || conf.File.CacheSeconds != 10 {
+		t.Error("Expected the mount to be cached for 10 seconds")
+	}
+	if !conf.PerCaller || !conf.Directory.PerCaller || !conf.File.PerCaller {
+		t.Error("Expected the mount's content to be keyed on the caller")
+	}
+	if conf = getMountConfig("/mnt/tree", Options{DirMode: 0o500, FileMode: 0o400}); conf.Cache || conf.File.Mode != 0o400 || conf.Mode != 0o500 {
+		t.Errorf("Expected an uncached mount with the provided modes, got %+v", conf)
+	}
+}